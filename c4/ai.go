@@ -0,0 +1,643 @@
+package c4
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An artificial intelligence that runs to some depth.
+// Set depth to -1 for unlimited depth. Every search goes at least one ply.
+// If Table is set, searched positions are remembered in it, and a position
+// and its mirror image share what's remembered. Only half the moves from a
+// symmetric position are searched. Both rely on EvalFunc scoring mirror
+// images the same.
+// If MoveTime is set, each move is searched one ply deeper at a time until
+// the time runs out or Depth is reached, and the best move from the deepest
+// finished search is played. If TimeLeft is set, the AI is playing with a
+// clock, and MoveTime is worked out from the time left, the Increment it gets
+// after every move and how many moves it could have left to play. If
+// Evaluator is set, it scores positions instead of EvalFunc, keeping what it
+// counts up to date as the search plays and takes back moves. Progress,
+// if set, is called with the result so far each time a depth is finished.
+//
+// The search is shared between Workers goroutines, or GOMAXPROCS goroutines
+// if that isn't set, in the way set by Parallelism. Moves that score the same are
+// chosen between at random if Seed is set, or by preferring the center if
+// not. Searches to a fixed depth choose the same move for the same Seed
+// however the goroutines happen to run.
+type AlphaBetaAI struct {
+	Color        Piece
+	Depth        int
+	EvalFunc     func(State, Piece) float64
+	Evaluator    IncrementalEvaluator
+	TerminalTest func(State) bool
+	Table        *TranspositionTable
+	MoveTime     time.Duration
+	TimeLeft     time.Duration
+	Increment    time.Duration
+	Workers      int
+	Parallelism  int
+	Seed         int64
+	Progress     func(SearchResult)
+}
+
+// Ways to share a search between goroutines
+const (
+	// Each goroutine searches different moves from the root
+	RootSplit = iota
+	// Every goroutine searches every move, sharing what they find through
+	// the Table. Only the best moves get exact scores.
+	LazySMP
+)
+
+// The score of a win on the first move. Wins are scored as WinScore less
+// the number of pieces played, so that quicker wins score higher, and losses
+// are scored as the negative of that, so that later losses score higher.
+// This is far outside the range of any sensible EvalFunc.
+const WinScore = 1e9
+
+// Scores a won game from the AI's point of view
+func (ai AlphaBetaAI) winScore(game State, winner Piece) float64 {
+	score := WinScore - float64(game.moveCount())
+	if winner != ai.Color {
+		return -score
+	}
+	return score
+}
+
+// The size of the table used for deepening when the AI doesn't have one
+const defaultTableSize = 16
+
+// Orders the columns to search from the center out, trying the given
+// column first, if any. Only the first n columns of the order are used.
+func moveOrder(game State, first int) (order [MaxColumns]int, n int) {
+	if first >= 0 {
+		order[n] = first
+		n++
+	}
+	for _, col := range game.geometry.colOrder {
+		if col != first {
+			order[n] = col
+			n++
+		}
+	}
+	return
+}
+
+// A single search for a move, which can be stopped partway through
+type search struct {
+	AlphaBetaAI
+	stopped int32
+	// Stopping the parent also stops this search
+	parent *search
+}
+
+func (s *search) stop() {
+	atomic.StoreInt32(&s.stopped, 1)
+}
+
+func (s *search) isStopped() bool {
+	return atomic.LoadInt32(&s.stopped) != 0 ||
+		s.parent != nil && s.parent.isStopped()
+}
+
+// Stops the search when the context is done, until the returned function
+// is called
+func (s *search) stopWhenDone(ctx context.Context) func() {
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.stop()
+		case <-finished:
+		}
+	}()
+	return func() {
+		close(finished)
+	}
+}
+
+// The most plies a search can look ahead, on the largest board
+const maxPly = 64
+
+// Searches from a single goroutine, keeping its own statistics and
+// principal variations
+type worker struct {
+	*search
+	nodes     int64
+	cutoffs   int64
+	tableHits int64
+	// The best line found from each ply
+	pv    [maxPly + 1][maxPly]int
+	pvLen [maxPly + 1]int
+	// Kept up to date with the position being searched, if the AI has an
+	// Evaluator
+	incremental Incremental
+}
+
+// Gets ready to search from a position
+func (w *worker) start(game State) {
+	if w.Evaluator != nil {
+		w.incremental = w.Evaluator.NewIncremental(game)
+	}
+}
+
+// Searches the position after col is played, returning false if it can't
+// be played
+func (w *worker) searchMove(game State, col, depth, ply int,
+	alpha, beta float64) (float64, bool) {
+	nextState, err := game.AfterMove(game.GetTurn(), col)
+	if err != nil {
+		return 0, false
+	}
+	if w.incremental != nil {
+		w.incremental.Moved(nextState, col)
+	}
+	score := w.alphabeta(nextState, depth, ply, alpha, beta)
+	if w.incremental != nil {
+		w.incremental.Undone(game, col)
+	}
+	return score, true
+}
+
+// Records that col, followed by the best line from the next ply, is the best
+// line from this ply
+func (w *worker) updatePV(ply, col int) {
+	w.pv[ply][0] = col
+	copy(w.pv[ply][1:], w.pv[ply+1][:w.pvLen[ply+1]])
+	w.pvLen[ply] = w.pvLen[ply+1] + 1
+}
+
+func (w *worker) alphabeta(game State,
+	depth, ply int, alpha, beta float64) float64 {
+	w.pvLen[ply] = 0
+	if w.isStopped() {
+		return 0
+	}
+	w.nodes++
+	if winner := game.GetWinner(); winner != None {
+		return w.winScore(game, winner)
+	}
+	if depth == 0 || w.TerminalTest(game) {
+		if w.incremental != nil {
+			return w.incremental.Eval(game, w.Color)
+		}
+		return w.EvalFunc(game, w.Color)
+	}
+	// Check whether we've seen this position or its mirror image before.
+	// Moves are stored for whichever one the key was made from.
+	var key uint64
+	var mirrored bool
+	columns := game.geometry.rules.Columns
+	hashMove := -1
+	if w.Table != nil {
+		key, mirrored = game.canonicalHash()
+		if entry, ok := w.Table.probe(key); ok {
+			// Only scores from searches of the same depth are used, so that
+			// the score doesn't depend on what other goroutines have stored
+			if entry.depth == depth {
+				if entry.bound == exactBound ||
+					entry.bound == lowerBound && entry.score >= beta ||
+					entry.bound == upperBound && entry.score <= alpha {
+					w.tableHits++
+					return entry.score
+				}
+			}
+			hashMove = entry.move
+			if mirrored && hashMove >= 0 {
+				hashMove = columns - 1 - hashMove
+			}
+		}
+	}
+	origAlpha, origBeta := alpha, beta
+	order, n := moveOrder(game, hashMove)
+	bestMove := -1
+	var score float64
+	if game.GetTurn() == w.Color {
+		for _, col := range order[:n] {
+			var ok bool
+			if score, ok = w.searchMove(game, col, depth-1, ply+1,
+				alpha, beta); ok {
+				if score > alpha || bestMove < 0 {
+					bestMove = col
+				}
+				if score > alpha {
+					alpha = score
+					w.updatePV(ply, col)
+				}
+				if beta <= alpha {
+					w.cutoffs++
+					break
+				}
+			}
+		}
+		score = alpha
+	} else {
+		for _, col := range order[:n] {
+			var ok bool
+			if score, ok = w.searchMove(game, col, depth-1, ply+1,
+				alpha, beta); ok {
+				if score < beta || bestMove < 0 {
+					bestMove = col
+				}
+				if score < beta {
+					beta = score
+					w.updatePV(ply, col)
+				}
+				if beta <= alpha {
+					w.cutoffs++
+					break
+				}
+			}
+		}
+		score = beta
+	}
+	// Scores from unfinished searches can't be trusted
+	if w.Table != nil && !w.isStopped() {
+		bound := exactBound
+		if score <= origAlpha {
+			bound = upperBound
+		} else if score >= origBeta {
+			bound = lowerBound
+		}
+		if mirrored && bestMove >= 0 {
+			bestMove = columns - 1 - bestMove
+		}
+		w.Table.store(key, depth, bound, bestMove, score)
+	}
+	return score
+}
+
+type MoveScore struct {
+	Col   int
+	Score float64
+}
+
+// What searching every move from a state found
+type rootResult struct {
+	scores    [MaxColumns]float64
+	pvs       [MaxColumns][]int
+	nodes     int64
+	cutoffs   int64
+	tableHits int64
+}
+
+// Scores every move to the given depth, searching the moves in the given
+// order. This returns false if the search was stopped before it finished.
+func (s *search) scoreMoves(game State, depth int, order []int) (
+	result rootResult, ok bool) {
+	for col := range result.scores {
+		// Illegal moves are very bad
+		result.scores[col] = math.Inf(-1)
+	}
+	// On a symmetric board, the moves on the right score the same as their
+	// mirror images on the left
+	columns := game.geometry.rules.Columns
+	symmetric := game.IsSymmetric()
+	if symmetric {
+		var left []int
+		for _, col := range order {
+			if col <= columns-1-col {
+				left = append(left, col)
+			}
+		}
+		order = left
+	}
+	workerCount := s.Workers
+	if workerCount <= 0 {
+		workerCount = runtime.GOMAXPROCS(0)
+	}
+	if s.Parallelism == LazySMP {
+		s.scoreMovesShared(game, depth, order, workerCount, &result)
+	} else {
+		s.scoreMovesSplit(game, depth, order, workerCount, &result)
+	}
+	if symmetric {
+		for col := columns/2 + columns%2; col < columns; col++ {
+			left := columns - 1 - col
+			if result.pvs[left] == nil {
+				continue
+			}
+			result.scores[col] = result.scores[left]
+			result.pvs[col] = make([]int, len(result.pvs[left]))
+			for i, c := range result.pvs[left] {
+				result.pvs[col][i] = columns - 1 - c
+			}
+		}
+	}
+	return result, !s.isStopped()
+}
+
+// Adds the counts from the workers to the result
+func (result *rootResult) count(workers []worker) {
+	for i := range workers {
+		result.nodes += workers[i].nodes
+		result.cutoffs += workers[i].cutoffs
+		result.tableHits += workers[i].tableHits
+	}
+}
+
+// Scores the moves from the root with each worker taking the next move
+// that hasn't been searched
+func (s *search) scoreMovesSplit(game State, depth int, order []int,
+	workerCount int, result *rootResult) {
+	moves := make(chan int, len(order))
+	for _, col := range order {
+		moves <- col
+	}
+	close(moves)
+
+	workers := make([]worker, workerCount)
+	var wg sync.WaitGroup
+	for i := range workers {
+		workers[i].search = s
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.start(game)
+			for col := range moves {
+				if score, ok := w.searchMove(game, col, depth-1, 1,
+					math.Inf(-1), math.Inf(+1)); ok {
+					// Each worker only writes the results for its own moves
+					result.scores[col] = score
+					result.pvs[col] = append([]int{col}, w.pv[1][:w.pvLen[1]]...)
+				}
+			}
+		}(&workers[i])
+	}
+	wg.Wait()
+	result.count(workers)
+}
+
+// Scores the moves from the root with the first worker, while the others
+// search the same tree to fill the table with what the first will need.
+// Half of the helpers search a ply deeper and each starts on a different
+// move, so that they don't all follow the same path.
+func (s *search) scoreMovesShared(game State, depth int, order []int,
+	workerCount int, result *rootResult) {
+	if len(order) == 0 {
+		return
+	}
+	workers := make([]worker, workerCount)
+	helpers := &search{AlphaBetaAI: s.AlphaBetaAI, parent: s}
+	var wg sync.WaitGroup
+	for i := 1; i < workerCount; i++ {
+		workers[i].search = helpers
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rotated := append(append([]int{}, order[i%len(order):]...),
+				order[:i%len(order)]...)
+			var ignored rootResult
+			workers[i].searchRoot(game, depth+i%2, rotated, &ignored)
+		}(i)
+	}
+	workers[0].search = s
+	workers[0].searchRoot(game, depth, order, result)
+	helpers.stop()
+	wg.Wait()
+	result.count(workers)
+}
+
+// Searches every move from the root in turn, only finding exact scores for
+// the moves that are at least as good as the ones before
+func (w *worker) searchRoot(game State, depth int, order []int,
+	result *rootResult) {
+	w.start(game)
+	alpha := math.Inf(-1)
+	for _, col := range order {
+		if w.isStopped() {
+			return
+		}
+		// Searching from just below the best score still gives moves that
+		// tie with it exact scores
+		if score, ok := w.searchMove(game, col, depth-1, 1,
+			math.Nextafter(alpha, math.Inf(-1)), math.Inf(+1)); ok {
+			result.scores[col] = score
+			result.pvs[col] = append([]int{col}, w.pv[1][:w.pvLen[1]]...)
+			alpha = math.Max(alpha, score)
+		}
+	}
+}
+
+// Orders the legal moves from best to worst by their scores from the last
+// search, or from the center out if there wasn't one
+func (root rootResult) order(game State) []int {
+	order := make([]int, 0, MaxColumns)
+	for _, col := range game.geometry.colOrder {
+		if game.IsLegal(game.GetTurn(), col) {
+			order = append(order, col)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return root.scores[order[i]] > root.scores[order[j]]
+	})
+	return order
+}
+
+// Picks the move with the best score
+func (ai AlphaBetaAI) pickMove(game State, scores [MaxColumns]float64) int {
+	var r *rand.Rand
+	if ai.Seed != 0 {
+		// Seeding from the position keeps the choice the same every time
+		// the position is searched
+		r = rand.New(rand.NewSource(ai.Seed ^ int64(game.Hash())))
+	}
+	bestMove := -1
+	bestScore := math.Inf(-1)
+	ties := 0
+	center := float64(game.GetRules().Columns/2) + 0.25
+	for col, score := range scores[:game.GetRules().Columns] {
+		if score > bestScore {
+			bestMove = col
+			bestScore = score
+			ties = 1
+			// If our heuristic isn't very smooth, add randomness to prevent
+			// predictability, or prefer the center
+		} else if score == bestScore {
+			ties++
+			if r != nil {
+				if r.Intn(ties) == 0 {
+					bestMove = col
+				}
+			} else if math.Abs(float64(col)-center) <
+				math.Abs(float64(bestMove)-center) {
+				bestMove = col
+			}
+		}
+	}
+	return bestMove
+}
+
+// What a search found, and how much work it took
+type SearchResult struct {
+	Move      int           // The move to play
+	Score     float64       // The score of that move
+	Scores    []MoveScore   // The scores of every legal move
+	PV        []int         // The line of play expected, starting with Move
+	Depth     int           // The depth of the deepest finished search
+	Nodes     int64         // The number of positions searched
+	Cutoffs   int64         // The number of alpha-beta cutoffs
+	TableHits int64         // The number of scores taken from the Table
+	Elapsed   time.Duration // How long the search took
+}
+
+// Keeps what was found by searching to some depth
+func (result *SearchResult) update(depth int, root rootResult, move int) {
+	result.Move = move
+	result.Score = root.scores[result.Move]
+	result.Scores = result.Scores[:0]
+	for col, score := range root.scores {
+		if root.pvs[col] != nil {
+			result.Scores = append(result.Scores, MoveScore{col, score})
+		}
+	}
+	result.PV = root.pvs[result.Move]
+	result.Depth = depth
+}
+
+func (ai AlphaBetaAI) NextMove(game State) int {
+	return ai.NextMoveContext(context.Background(), game)
+}
+
+// Finds the next move like Analyze, stopping when the context is done
+func (ai AlphaBetaAI) NextMoveContext(ctx context.Context, game State) int {
+	return ai.AnalyzeContext(ctx, game).Move
+}
+
+// Searches for the next move, reporting how the AI decided on it
+func (ai AlphaBetaAI) Analyze(game State) SearchResult {
+	return ai.AnalyzeContext(context.Background(), game)
+}
+
+// Searches for the next move, deepening the search one ply at a time until
+// the context is done or MoveTime passes. The search to one ply is always
+// finished, so there is always a move to play.
+func (ai AlphaBetaAI) AnalyzeContext(ctx context.Context,
+	game State) (result SearchResult) {
+	start := time.Now()
+	result.Move = -1
+	defer func() {
+		result.Elapsed = time.Since(start)
+	}()
+
+	// Searching to the end of the game is as deep as we can go
+	rules := game.GetRules()
+	maxDepth := rules.Columns*rules.Rows - game.moveCount()
+	if ai.Depth >= 0 && ai.Depth < maxDepth {
+		maxDepth = ai.Depth
+	}
+	// Even a Depth of 0 searches one ply, so there's a move to play
+	if maxDepth < 1 {
+		maxDepth = 1
+	}
+
+	if ai.TimeLeft > 0 {
+		budget := ai.timeBudget(game)
+		if ai.MoveTime == 0 || budget < ai.MoveTime {
+			ai.MoveTime = budget
+		}
+	}
+	if ai.MoveTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ai.MoveTime)
+		defer cancel()
+	}
+
+	// Each search orders its moves by the best moves the last one left in
+	// the table, and the workers in a shared search talk through it
+	if ai.Table == nil &&
+		(ctx.Done() != nil || ai.Parallelism == LazySMP) {
+		ai.Table = NewTranspositionTable(defaultTableSize)
+	}
+	if ai.Table != nil {
+		ai.Table.newSearch()
+	}
+
+	// If nothing can stop the search, go straight to the full depth
+	if ctx.Done() == nil {
+		root, _ := (&search{AlphaBetaAI: ai}).scoreMoves(
+			game, maxDepth, rootResult{}.order(game))
+		result.update(maxDepth, root, ai.pickMove(game, root.scores))
+		result.Nodes = root.nodes
+		result.Cutoffs = root.cutoffs
+		result.TableHits = root.tableHits
+		if ai.Progress != nil {
+			result.Elapsed = time.Since(start)
+			ai.Progress(result)
+		}
+		return
+	}
+
+	var last rootResult
+	for depth := 1; depth <= maxDepth; depth++ {
+		s := &search{AlphaBetaAI: ai}
+		finished := func() {}
+		if depth > 1 {
+			finished = s.stopWhenDone(ctx)
+		}
+		root, ok := s.scoreMoves(game, depth, last.order(game))
+		finished()
+		result.Nodes += root.nodes
+		result.Cutoffs += root.cutoffs
+		result.TableHits += root.tableHits
+		if !ok {
+			break
+		}
+		result.update(depth, root, ai.pickMove(game, root.scores))
+		last = root
+		if ai.Progress != nil {
+			result.Elapsed = time.Since(start)
+			ai.Progress(result)
+		}
+	}
+	return
+}
+
+// Sets the AI up to move with some time left on its clock
+func (ai AlphaBetaAI) WithClock(control TimeControl, left time.Duration) Player {
+	if control.PerMove > 0 {
+		// Every move gets the same time, so all of it can be used
+		left = keepReserve(left)
+		if ai.MoveTime == 0 || left < ai.MoveTime {
+			ai.MoveTime = left
+		}
+		ai.TimeLeft = 0
+	} else {
+		ai.TimeLeft = left
+		ai.Increment = control.Increment
+	}
+	return ai
+}
+
+// Splits the time left on the clock between the moves the AI could have left
+// to play, along with most of the increment it gets back. Games often end
+// long before the board fills, so this leaves time to spare.
+func (ai AlphaBetaAI) timeBudget(game State) time.Duration {
+	rules := game.GetRules()
+	movesLeft := (rules.Columns*rules.Rows - game.moveCount() + 1) / 2
+	if movesLeft < 1 {
+		movesLeft = 1
+	}
+	budget := ai.TimeLeft/time.Duration(movesLeft) + ai.Increment*3/4
+	// Never bet more than half the clock on one move
+	if budget > ai.TimeLeft/2 {
+		budget = ai.TimeLeft / 2
+	}
+	return keepReserve(budget)
+}
+
+// Leaves a little time for the search to stop and the move to be played
+func keepReserve(t time.Duration) time.Duration {
+	reserve := t / 10
+	if reserve > 50*time.Millisecond {
+		reserve = 50 * time.Millisecond
+	}
+	return t - reserve
+}
@@ -0,0 +1,327 @@
+package c4
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// An AI with the coefficients found by ga
+func testAI(game State, depth int) AlphaBetaAI {
+	return AlphaBetaAI{
+		Color:    game.GetTurn(),
+		Depth:    depth,
+		EvalFunc: testFactors.Eval,
+		TerminalTest: func(game State) bool {
+			return game.GetWinner() != None
+		},
+	}
+}
+
+// A Depth of 0 still finds a move, with or without a deadline
+func TestDepthZeroPlaysAMove(t *testing.T) {
+	game, _ := ParseMoves("4453")
+	ai := testAI(game, 0)
+	if move := ai.NextMove(game); !game.IsLegal(game.GetTurn(), move) {
+		t.Errorf("Without a deadline, played %v", move)
+	}
+	ai.MoveTime = 100 * time.Millisecond
+	if move := ai.NextMove(game); !game.IsLegal(game.GetTurn(), move) {
+		t.Errorf("With MoveTime, played %v", move)
+	}
+	ai.MoveTime = 0
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if move := ai.NextMoveContext(ctx, game); !game.IsLegal(game.GetTurn(), move) {
+		t.Errorf("With a context, played %v", move)
+	}
+}
+
+// Scores the AI gives the moves from a position, searching to the end of
+// the game
+func scoresToEnd(t *testing.T, rules Rules, board string) (SearchResult,
+	map[int]float64) {
+	game, err := rules.ParseBoard(board)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testAI(game, -1).Analyze(game)
+	scores := make(map[int]float64)
+	for _, s := range result.Scores {
+		scores[s.Col] = s.Score
+	}
+	return result, scores
+}
+
+// With wins in one and in three to choose from, the win in one is played
+// and scored by when it happens
+func TestPrefersQuickerWin(t *testing.T) {
+	// Red wins now with a line in column 2 or the second row, or later
+	// anywhere else
+	result, scores := scoresToEnd(t, Rules{5, 4, 3},
+		"...../...../R.R.R/BBRBB R")
+	if result.Move != 1 && result.Move != 2 && result.Move != 3 {
+		t.Errorf("Played %v instead of winning", result.Move)
+	}
+	if result.Score != WinScore-9 {
+		t.Errorf("The win scored %v, want %v", result.Score, WinScore-9)
+	}
+	if scores[0] != WinScore-11 || scores[4] != WinScore-11 {
+		t.Errorf("The slower wins scored %v and %v, want %v", scores[0],
+			scores[4], WinScore-11)
+	}
+}
+
+// With every move losing, the move that loses last is played
+func TestDelaysLoss(t *testing.T) {
+	// Red loses two moves later by playing in column 2, and on black's
+	// next move otherwise
+	result, scores := scoresToEnd(t, Rules{5, 4, 3},
+		"...../....B/R.B.R/BRB.R R")
+	if result.Move != 2 {
+		t.Errorf("Played %v instead of 2", result.Move)
+	}
+	if result.Score != -(WinScore - 14) {
+		t.Errorf("The loss scored %v, want %v", result.Score, -(WinScore - 14))
+	}
+	for _, col := range []int{0, 1, 3, 4} {
+		if scores[col] != -(WinScore - 10) {
+			t.Errorf("Column %v scored %v, want %v", col, scores[col],
+				-(WinScore - 10))
+		}
+	}
+}
+
+// On the standard board, a win in one is taken over setting up a double
+// threat
+func TestTakesImmediateWin(t *testing.T) {
+	// Red has three in a row along the bottom with both ends open, and
+	// black has three in the last column
+	game, err := ParseMoves("273747")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := testAI(game, 6).Analyze(game)
+	if result.Move != 0 && result.Move != 4 {
+		t.Errorf("Played %v instead of winning", result.Move)
+	}
+	if result.Score != WinScore-float64(len(game.Moves())+1) {
+		t.Errorf("The win scored %v, want %v", result.Score,
+			WinScore-float64(len(game.Moves())+1))
+	}
+}
+
+// The ways of sharing a search, by name
+var parallelisms = map[string]int{"RootSplit": RootSplit, "LazySMP": LazySMP}
+
+// Several AIs with more than one worker each search at once, sharing a
+// table, some with a deadline and some cancelled part way through. Run with
+// -race to check the workers don't trip over each other.
+func TestParallelSearches(t *testing.T) {
+	positions := []string{"", "4453", "3443", "45345362"}
+	for name, parallelism := range parallelisms {
+		t.Run(name, func(t *testing.T) {
+			table := NewTranspositionTable(1)
+			var wg sync.WaitGroup
+			for i, moves := range positions {
+				game, err := ParseMoves(moves)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The table is only shared by searches for the same color
+				if game.GetTurn() != Red {
+					continue
+				}
+				for j := 0; j < 3; j++ {
+					ai := testAI(game, 6)
+					ai.Table = table
+					ai.Workers = 4
+					ai.Parallelism = parallelism
+					ctx, cancel := context.WithCancel(context.Background())
+					switch j {
+					case 1:
+						ai.Depth = -1
+						ai.MoveTime = 50 * time.Millisecond
+					case 2:
+						ai.Depth = -1
+						time.AfterFunc(time.Duration(10*i)*time.Millisecond,
+							cancel)
+					}
+					wg.Add(1)
+					go func(ai AlphaBetaAI, game State) {
+						defer wg.Done()
+						defer cancel()
+						move := ai.NextMoveContext(ctx, game)
+						if !game.IsLegal(game.GetTurn(), move) {
+							t.Errorf("%q: played %v", game.MoveString(), move)
+						}
+					}(ai, game)
+				}
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// Searches to a fixed depth with the same Seed choose the same move and
+// find the same score, whatever the number of workers
+func TestSeedIsDeterministic(t *testing.T) {
+	for name, parallelism := range parallelisms {
+		t.Run(name, func(t *testing.T) {
+			for _, moves := range []string{"", "4453", "3443", "45345362"} {
+				game, err := ParseMoves(moves)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ai := testAI(game, 6)
+				ai.Seed = 7
+				ai.Parallelism = parallelism
+				ai.Workers = 1
+				want := ai.Analyze(game)
+				for _, workers := range []int{1, 2, 4, 8} {
+					for i := 0; i < 3; i++ {
+						ai.Workers = workers
+						got := ai.Analyze(game)
+						if got.Move != want.Move || got.Score != want.Score {
+							t.Fatalf("%q with %v workers: played %v "+
+								"scoring %v, want %v scoring %v", moves,
+								workers, got.Move, got.Score, want.Move,
+								want.Score)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// A position and its mirror image get the same scores for mirrored moves,
+// whether or not they share a table
+func TestMirrorScoresSame(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		var table *TranspositionTable
+		if shared {
+			table = NewTranspositionTable(1)
+		}
+		for _, game := range randomPositions(StandardRules, 20, 5) {
+			mirrored := game.Mirror()
+			ai := testAI(game, 6)
+			ai.Workers = 1
+			ai.Table = table
+			scores := make(map[int]float64)
+			for _, s := range ai.Analyze(game).Scores {
+				scores[s.Col] = s.Score
+			}
+			for _, s := range ai.Analyze(mirrored).Scores {
+				col := StandardRules.Columns - 1 - s.Col
+				if s.Score != scores[col] {
+					t.Fatalf("%q with shared table %v: column %v scored %v, "+
+						"and %v in the mirror image", game.MoveString(), shared,
+						col, scores[col], s.Score)
+				}
+			}
+			if testFactors.Eval(game, Red) != testFactors.Eval(mirrored, Red) {
+				t.Fatalf("%q: evaluated as %v, and %v in the mirror image",
+					game.MoveString(), testFactors.Eval(game, Red),
+					testFactors.Eval(mirrored, Red))
+			}
+		}
+	}
+}
+
+// Checks that a result's PV is a legal line starting with its move, and
+// that it scores exactly the legal moves
+func checkResult(t *testing.T, game State, result SearchResult) {
+	t.Helper()
+	if len(result.PV) == 0 || result.PV[0] != result.Move {
+		t.Fatalf("%q: PV %v doesn't start with %v", game.MoveString(),
+			result.PV, result.Move)
+	}
+	if len(result.PV) > result.Depth {
+		t.Fatalf("%q: PV %v is longer than depth %v", game.MoveString(),
+			result.PV, result.Depth)
+	}
+	line := game
+	for _, col := range result.PV {
+		if line.IsDone() {
+			t.Fatalf("%q: PV %v goes on after the game ends",
+				game.MoveString(), result.PV)
+		}
+		if err := line.Move(line.GetTurn(), col); err != nil {
+			t.Fatalf("%q: PV %v: %v", game.MoveString(), result.PV, err)
+		}
+	}
+	scored := make(map[int]bool)
+	for _, s := range result.Scores {
+		if !game.IsLegal(game.GetTurn(), s.Col) || scored[s.Col] {
+			t.Fatalf("%q: scored %v", game.MoveString(), result.Scores)
+		}
+		scored[s.Col] = true
+		if s.Col == result.Move && s.Score != result.Score {
+			t.Fatalf("%q: move %v scored %v and %v", game.MoveString(),
+				s.Col, s.Score, result.Score)
+		}
+	}
+	for col := 0; col < game.GetRules().Columns; col++ {
+		if game.IsLegal(game.GetTurn(), col) && !scored[col] {
+			t.Fatalf("%q: didn't score %v in %v", game.MoveString(), col,
+				result.Scores)
+		}
+	}
+}
+
+func TestSearchResult(t *testing.T) {
+	for name, parallelism := range parallelisms {
+		for _, game := range randomPositions(StandardRules, 20, 12) {
+			ai := testAI(game, 5)
+			ai.Parallelism = parallelism
+			result := ai.Analyze(game)
+			checkResult(t, game, result)
+			// Near the end of the game, the search stops at the last move
+			want := 5
+			if left := StandardRules.Columns*StandardRules.Rows -
+				len(game.Moves()); left < want {
+				want = left
+			}
+			if result.Depth != want {
+				t.Errorf("%v %q: depth %v, want %v", name, game.MoveString(),
+					result.Depth, want)
+			}
+			if result.Nodes <= 0 || want >= 3 && result.Cutoffs <= 0 {
+				t.Errorf("%v %q: %v nodes, %v cutoffs", name,
+					game.MoveString(), result.Nodes, result.Cutoffs)
+			}
+		}
+	}
+}
+
+// Searches that run out of time report the depth they last finished, which
+// is the last one Progress was told about
+func TestSearchResultWithDeadline(t *testing.T) {
+	game, err := ParseMoves("4453")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ai := testAI(game, -1)
+	ai.MoveTime = 100 * time.Millisecond
+	var last SearchResult
+	ai.Progress = func(result SearchResult) {
+		checkResult(t, game, result)
+		if result.Depth != last.Depth+1 {
+			t.Errorf("Finished depth %v after %v", result.Depth, last.Depth)
+		}
+		last = result
+	}
+	result := ai.Analyze(game)
+	checkResult(t, game, result)
+	if result.Depth != last.Depth || result.Move != last.Move ||
+		result.Depth < 2 {
+		t.Errorf("Finished depth %v with move %v, last reported %v with %v",
+			result.Depth, result.Move, last.Depth, last.Move)
+	}
+	if result.Nodes < last.Nodes || result.Cutoffs <= 0 {
+		t.Errorf("%v nodes, %v cutoffs, %v nodes reported", result.Nodes,
+			result.Cutoffs, last.Nodes)
+	}
+}
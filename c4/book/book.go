@@ -0,0 +1,220 @@
+// Package book keeps opening books: the moves worth playing in positions
+// near the start of the game, each with a weight saying how often to play
+// it. A position and its mirror image share an entry, with the moves
+// mirrored to match.
+//
+// Books are stored as JSON, giving each position by the moves that reach it
+// in the notation of c4.ParseMoves:
+//
+//	{
+//		"Rules": {"Columns":7,"Rows":6,"WinCount":4},
+//		"Positions": [
+//			{"Position":"","Moves":[{"Column":3,"Weight":10}]},
+//			{"Position":"4","Moves":[{"Column":3,"Weight":10},{"Column":2,"Weight":4}]}
+//		]
+//	}
+//
+// Columns in Moves are numbered from 0, like everywhere else but notation.
+package book
+
+import (
+	".."
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+)
+
+// A move to play from a position
+type Move struct {
+	Column int
+	// How often to play the move, relative to the other moves for the
+	// position
+	Weight int
+}
+
+// What the book knows about a position, in the orientation it was added in
+type entry struct {
+	Position string
+	Moves    []Move
+}
+
+// A book of positions for one set of rules
+type Book struct {
+	Rules     c4.Rules
+	positions map[uint64]entry
+}
+
+// Makes an empty book
+func New(rules c4.Rules) *Book {
+	return &Book{Rules: rules, positions: make(map[uint64]entry)}
+}
+
+// Finds the key for a position, which is its canonical hash, and whether
+// the position is the mirror image of the one the key was made from
+func keyFor(game c4.State) (key uint64, mirrored bool) {
+	key = game.CanonicalHash()
+	return key, key != game.Hash()
+}
+
+// Mirrors moves from one orientation of a position to the other
+func mirror(rules c4.Rules, moves []Move) []Move {
+	mirrored := make([]Move, len(moves))
+	for i, m := range moves {
+		mirrored[i] = Move{rules.Columns - 1 - m.Column, m.Weight}
+	}
+	return mirrored
+}
+
+// The number of positions in the book
+func (b *Book) Len() int {
+	return len(b.positions)
+}
+
+// Sets the moves for a position, replacing any that were there. The
+// position has to have been played from the start of the game, rather than
+// set up from a board.
+func (b *Book) Add(game c4.State, moves []Move) error {
+	if game.GetRules() != b.Rules {
+		return errors.New("The position doesn't use the book's rules")
+	}
+	pieces := 0
+	for col := 0; col < b.Rules.Columns; col++ {
+		pieces += game.GetTop(col)
+	}
+	if len(game.Moves()) != pieces {
+		return errors.New("The position wasn't played from the start")
+	}
+	for _, m := range moves {
+		if !game.IsLegal(game.GetTurn(), m.Column) {
+			return errors.New(fmt.Sprintf("Illegal move %v", m.Column))
+		}
+		if m.Weight < 0 {
+			return errors.New(fmt.Sprintf("Negative weight %v", m.Weight))
+		}
+	}
+	k, mirrored := keyFor(game)
+	// Keep the position in the orientation its key was made from
+	e := entry{game.MoveString(), append([]Move{}, moves...)}
+	if mirrored {
+		e = entry{mirrorMoveString(b.Rules, e.Position),
+			mirror(b.Rules, moves)}
+	}
+	b.positions[k] = e
+	return nil
+}
+
+// Mirrors a move string
+func mirrorMoveString(rules c4.Rules, s string) string {
+	game, _ := rules.ParseMoves(s)
+	moves := game.Moves()
+	mirrored := c4.NewState(rules)
+	for _, col := range moves {
+		mirrored.Move(mirrored.GetTurn(), rules.Columns-1-col)
+	}
+	return mirrored.MoveString()
+}
+
+// The moves for a position, or nil if it isn't in the book
+func (b *Book) Lookup(game c4.State) []Move {
+	if game.GetRules() != b.Rules {
+		return nil
+	}
+	k, mirrored := keyFor(game)
+	e, ok := b.positions[k]
+	if !ok {
+		return nil
+	}
+	if mirrored {
+		return mirror(b.Rules, e.Moves)
+	}
+	return append([]Move{}, e.Moves...)
+}
+
+// Picks one of the moves for a position at random by their weights,
+// returning false if there aren't any
+func (b *Book) Choose(game c4.State) (int, bool) {
+	moves := b.Lookup(game)
+	total := 0
+	for _, m := range moves {
+		total += m.Weight
+	}
+	if total == 0 {
+		return -1, false
+	}
+	n := rand.Intn(total)
+	for _, m := range moves {
+		if n < m.Weight {
+			return m.Column, true
+		}
+		n -= m.Weight
+	}
+	return -1, false
+}
+
+// How a book is stored
+type bookFile struct {
+	Rules     c4.Rules
+	Positions []entry
+}
+
+// Writes the book as JSON, with a line for each position, in order of
+// their moves
+func (b *Book) Write(w io.Writer) error {
+	positions := make([]entry, 0, len(b.positions))
+	for _, e := range b.positions {
+		positions = append(positions, e)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		pi, pj := positions[i].Position, positions[j].Position
+		if len(pi) != len(pj) {
+			return len(pi) < len(pj)
+		}
+		return pi < pj
+	})
+
+	rules, err := json.Marshal(b.Rules)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "{\n\t\"Rules\": %s,\n\t\"Positions\": [", rules)
+	for i, e := range positions {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			out.WriteString(",")
+		}
+		fmt.Fprintf(out, "\n\t\t%s", line)
+	}
+	out.WriteString("\n\t]\n}\n")
+	return out.Flush()
+}
+
+// Reads a book written by Write
+func Read(r io.Reader) (*Book, error) {
+	var file bookFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	if err := file.Rules.Validate(); err != nil {
+		return nil, err
+	}
+	b := New(file.Rules)
+	for _, e := range file.Positions {
+		game, err := b.Rules.ParseMoves(e.Position)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Add(game, e.Moves); err != nil {
+			return nil, errors.New(fmt.Sprintf(
+				"Position %q: %v", e.Position, err))
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,192 @@
+package book
+
+import (
+	".."
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func parse(t *testing.T, moves string) c4.State {
+	game, err := c4.ParseMoves(moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return game
+}
+
+// A position and its mirror image share an entry, whichever is added
+func TestLookupMirrored(t *testing.T) {
+	b := New(c4.StandardRules)
+	moves := []Move{{3, 5}, {0, 1}}
+	if err := b.Add(parse(t, "12"), moves); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Lookup(parse(t, "12")); !reflect.DeepEqual(got, moves) {
+		t.Errorf("Got %v, want %v", got, moves)
+	}
+	mirrored := []Move{{3, 5}, {6, 1}}
+	if got := b.Lookup(parse(t, "76")); !reflect.DeepEqual(got, mirrored) {
+		t.Errorf("Got %v for the mirror image, want %v", got, mirrored)
+	}
+	if got := b.Lookup(parse(t, "13")); got != nil {
+		t.Errorf("Got %v for a position that isn't in the book", got)
+	}
+
+	// Adding the mirror image replaces the entry
+	if err := b.Add(parse(t, "76"), []Move{{5, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 1 {
+		t.Errorf("The book has %v positions, want 1", b.Len())
+	}
+	if got := b.Lookup(parse(t, "12")); !reflect.DeepEqual(got,
+		[]Move{{1, 2}}) {
+		t.Errorf("Got %v after replacing the mirror image", got)
+	}
+	if col, ok := b.Choose(parse(t, "76")); !ok || col != 5 {
+		t.Errorf("Chose %v, %v", col, ok)
+	}
+}
+
+func TestAddErrors(t *testing.T) {
+	b := New(c4.StandardRules)
+	board, err := c4.StandardRules.ParseBoard(
+		"......./......./......./......./......./...R... B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := c4.Rules{Columns: 8, Rows: 6, WinCount: 4}.ParseMoves("4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		game  c4.State
+		moves []Move
+	}{
+		{board, []Move{{3, 1}}},
+		{other, []Move{{3, 1}}},
+		{parse(t, "4"), []Move{{7, 1}}},
+		{parse(t, "444444"), []Move{{3, 1}}},
+		{parse(t, "4"), []Move{{2, -1}}},
+	}
+	for _, c := range cases {
+		if err := b.Add(c.game, c.moves); err == nil {
+			t.Errorf("Added %v to %v", c.moves, c.game.MoveString())
+		}
+	}
+	if b.Len() != 0 {
+		t.Errorf("The book has %v positions after errors", b.Len())
+	}
+	if col, ok := b.Choose(parse(t, "4")); ok {
+		t.Errorf("Chose %v from an empty book", col)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	b := New(c4.StandardRules)
+	positions := map[string][]Move{
+		"":    {{3, 10}},
+		"4":   {{3, 10}, {2, 4}},
+		"45":  {{3, 1}},
+		"21":  {{0, 3}, {5, 0}},
+		"443": {},
+	}
+	for moves, m := range positions {
+		if err := b.Add(parse(t, moves), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := b.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	written := buf.String()
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Rules != b.Rules || read.Len() != b.Len() {
+		t.Errorf("Read %v positions with rules %v", read.Len(), read.Rules)
+	}
+	for moves := range positions {
+		game := parse(t, moves)
+		got, want := read.Lookup(game), b.Lookup(game)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Read %v for %q, want %v", got, moves, want)
+		}
+	}
+
+	// Writing it again gives the same file
+	buf.Reset()
+	if err := read.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != written {
+		t.Errorf("Wrote\n%v\nthen\n%v", written, buf.String())
+	}
+}
+
+// A player that counts how often it's asked for a move
+type countingPlayer struct {
+	move  int
+	calls int
+}
+
+func (p *countingPlayer) NextMove(game c4.State) int {
+	p.calls++
+	return p.move
+}
+
+// Like countingPlayer, but it can explain its moves
+type countingAnalyzer struct {
+	countingPlayer
+	analyses int
+}
+
+func (p *countingAnalyzer) AnalyzeContext(ctx context.Context,
+	game c4.State) c4.SearchResult {
+	p.analyses++
+	return c4.SearchResult{Move: p.move, Depth: 3}
+}
+
+// The book is used while it has a move, and the other player is asked just
+// once after that
+func TestBookPlayer(t *testing.T) {
+	b := New(c4.StandardRules)
+	if err := b.Add(parse(t, ""), []Move{{3, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	other := &countingPlayer{move: 2}
+	p := BookPlayer{Book: b, Player: other}
+	empty := c4.NewState(c4.StandardRules)
+	if move := p.NextMove(empty); move != 3 || other.calls != 0 {
+		t.Errorf("Played %v with %v calls in the book", move, other.calls)
+	}
+	result := p.AnalyzeContext(context.Background(), empty)
+	if result.Move != 3 || other.calls != 0 {
+		t.Errorf("Analyzed %+v with %v calls in the book", result,
+			other.calls)
+	}
+
+	game := parse(t, "4")
+	if move := p.NextMove(game); move != 2 || other.calls != 1 {
+		t.Errorf("Played %v with %v calls out of the book", move,
+			other.calls)
+	}
+	result = p.AnalyzeContext(context.Background(), game)
+	if result.Move != 2 || other.calls != 2 {
+		t.Errorf("Analyzed %+v with %v calls out of the book", result,
+			other.calls)
+	}
+
+	analyzer := &countingAnalyzer{countingPlayer: countingPlayer{move: 5}}
+	p.Player = analyzer
+	result = p.AnalyzeContext(context.Background(), game)
+	if result.Move != 5 || result.Depth != 3 || analyzer.analyses != 1 ||
+		analyzer.calls != 0 {
+		t.Errorf("Analyzed %+v with %v analyses and %v calls", result,
+			analyzer.analyses, analyzer.calls)
+	}
+}
@@ -0,0 +1,58 @@
+package book
+
+import (
+	".."
+	"context"
+	"time"
+)
+
+// A player that plays from a book while it can, and asks another player for
+// moves after that. It can be used with a context, asked for an analysis or
+// given a clock whenever the other player can.
+type BookPlayer struct {
+	Book   *Book
+	Player c4.Player
+}
+
+func (p BookPlayer) NextMove(game c4.State) int {
+	return p.NextMoveContext(context.Background(), game)
+}
+
+func (p BookPlayer) NextMoveContext(ctx context.Context, game c4.State) int {
+	if col, ok := p.Book.Choose(game); ok {
+		return col
+	}
+	return p.otherMove(ctx, game)
+}
+
+// Asks the other player for a move
+func (p BookPlayer) otherMove(ctx context.Context, game c4.State) int {
+	if player, ok := p.Player.(c4.ContextPlayer); ok {
+		return player.NextMoveContext(ctx, game)
+	}
+	return p.Player.NextMove(game)
+}
+
+// Analyzes the position with the other player if it isn't in the book. Book
+// moves are reported with nothing but the move.
+func (p BookPlayer) AnalyzeContext(ctx context.Context,
+	game c4.State) c4.SearchResult {
+	if col, ok := p.Book.Choose(game); ok {
+		return c4.SearchResult{Move: col, PV: []int{col}}
+	}
+	if analyzer, ok := p.Player.(c4.Analyzer); ok {
+		return analyzer.AnalyzeContext(ctx, game)
+	}
+	start := time.Now()
+	col := p.otherMove(ctx, game)
+	return c4.SearchResult{Move: col, Elapsed: time.Since(start)}
+}
+
+// Passes the clock on to the other player, if it keeps time
+func (p BookPlayer) WithClock(control c4.TimeControl,
+	left time.Duration) c4.Player {
+	if player, ok := p.Player.(c4.TimedPlayer); ok {
+		p.Player = player.WithClock(control, left)
+	}
+	return p
+}
@@ -1,14 +1,15 @@
 package c4
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
+	"math/bits"
 )
 
-const MaxColumns = 7
-const MaxRows = 6
-const WinCount = 4
+// The largest board that Rules can describe
+const MaxColumns = 16
+const MaxRows = 16
 
 const (
 	None = iota
@@ -29,31 +30,48 @@ func (p Piece) Other() Piece {
 
 // This is all game state management
 
-// Note that row 0, column 0 is the lower-left
+// Note that row 0, column 0 is the lower-left.
+// Each player's pieces are kept in a bitboard, where the bit for a location
+// is col*Rows + row, so columns are runs of Rows consecutive bits.
+// The columns that were played are kept four bits to a move in history,
+// along with any moves that were undone and can still be redone.
 type State struct {
-	board    [MaxColumns][MaxRows]Piece
-	top      [MaxColumns]int
+	pieces   [2]uint64
+	top      [MaxColumns]uint8
 	turn     Piece
-	lastMove int
+	history  [4]uint64
+	played   uint8
+	recorded uint8
+	geometry *geometry
 }
 
-func NewState() State {
+// Starts a game with the given rules, which must be valid
+func NewState(rules Rules) State {
+	if err := rules.Validate(); err != nil {
+		panic(err)
+	}
 	return State{
-		[MaxColumns][MaxRows]Piece{},
-		[MaxColumns]int{},
+		[2]uint64{},
+		[MaxColumns]uint8{},
 		Red,
-		0}
+		[4]uint64{},
+		0,
+		0,
+		geometryFor(rules)}
 }
 
 func (this *State) Move(player Piece, col int) error {
 	// Catch all the invalid states and moves
-	if col >= 0 && col < MaxColumns &&
+	if col >= 0 && col < this.geometry.rules.Columns &&
 		(player == Red || player == Black) &&
 		player == this.turn &&
-		this.top[col] < MaxRows {
+		int(this.top[col]) < this.geometry.rules.Rows {
 		// Add the piece
-		this.lastMove = col
-		this.board[col][this.top[col]] = player
+		this.setHistory(int(this.played), col)
+		this.played++
+		// A new move replaces any moves that could have been redone
+		this.recorded = this.played
+		this.pieces[player-1] |= this.geometry.bit(col, int(this.top[col]))
 		// The next piece goes one row higher
 		this.top[col]++
 		// Change turns
@@ -64,6 +82,49 @@ func (this *State) Move(player Piece, col int) error {
 		"Invalid move by player %v to column %v", player, col))
 }
 
+// Takes back the last move
+func (this *State) Undo() error {
+	if this.played == 0 {
+		return errors.New("There are no moves to undo")
+	}
+	this.played--
+	col := this.getHistory(int(this.played))
+	this.top[col]--
+	this.turn = this.turn.Other()
+	this.pieces[this.turn-1] &^= this.geometry.bit(col, int(this.top[col]))
+	return nil
+}
+
+// Plays the last move that was undone again
+func (this *State) Redo() error {
+	if this.played == this.recorded {
+		return errors.New("There are no moves to redo")
+	}
+	recorded := this.recorded
+	err := this.Move(this.turn, this.getHistory(int(this.played)))
+	this.recorded = recorded
+	return err
+}
+
+// The columns that have been played, in order, since the start of the game
+// or the board it was set up from
+func (this State) Moves() []int {
+	moves := make([]int, this.played)
+	for i := range moves {
+		moves[i] = this.getHistory(i)
+	}
+	return moves
+}
+
+func (this State) getHistory(i int) int {
+	return int(this.history[i/16]>>(uint(i%16)*4)) & 0xF
+}
+
+func (this *State) setHistory(i, col int) {
+	shift := uint(i%16) * 4
+	this.history[i/16] = this.history[i/16]&^(0xF<<shift) | uint64(col)<<shift
+}
+
 func (this State) AfterMove(player Piece, col int) (game State, err error) {
 	game = this
 	err = game.Move(player, col)
@@ -71,113 +132,152 @@ func (this State) AfterMove(player Piece, col int) (game State, err error) {
 }
 
 func (this State) IsLegal(player Piece, col int) bool {
-	return this.top[col] < MaxRows && player == this.turn
+	return col >= 0 && col < this.geometry.rules.Columns &&
+		int(this.top[col]) < this.geometry.rules.Rows && player == this.turn
 }
 
 func (this State) GetPiece(col, row int) Piece {
-	if col >= 0 && col < MaxColumns &&
-		row >= 0 && row < MaxRows {
-		return this.board[col][row]
+	if col >= 0 && col < this.geometry.rules.Columns &&
+		row >= 0 && row < this.geometry.rules.Rows {
+		b := this.geometry.bit(col, row)
+		if this.pieces[0]&b != 0 {
+			return Red
+		} else if this.pieces[1]&b != 0 {
+			return Black
+		}
 	}
 	return None
 }
 
 func (this State) GetTop(col int) int {
-	if col >= 0 && col < MaxColumns {
-		return this.top[col]
+	if col >= 0 && col < this.geometry.rules.Columns {
+		return int(this.top[col])
 	}
 	return 0
 }
 
+func (this State) GetRules() Rules {
+	return this.geometry.rules
+}
+
 func (this State) GetTurn() Piece {
 	return this.turn
 }
 
 func (this State) GetWinner() Piece {
-	return lineTest(this, this.lastMove, this.top[this.lastMove]-1)
+	// Only the last move can have made a line, unless the position was set
+	// up from a board, in which case any of them could have
+	if this.played == 0 {
+		for col := 0; col < this.geometry.rules.Columns; col++ {
+			for row := 0; row < int(this.top[col]); row++ {
+				if winner := lineTest(this, col, row); winner != None {
+					return winner
+				}
+			}
+		}
+		return None
+	}
+	lastMove := this.getHistory(int(this.played) - 1)
+	return lineTest(this, lastMove, int(this.top[lastMove])-1)
+}
+
+func (this State) GetBoard() (board [MaxColumns][MaxRows]Piece) {
+	for col := 0; col < this.geometry.rules.Columns; col++ {
+		for row := 0; row < int(this.top[col]); row++ {
+			board[col][row] = this.GetPiece(col, row)
+		}
+	}
+	return
 }
 
-func (this State) GetBoard() [MaxColumns][MaxRows]Piece {
-	return this.board
+// The number of pieces that have been played
+func (this State) moveCount() int {
+	return bits.OnesCount64(this.pieces[0] | this.pieces[1])
 }
 
-func (this State) IsDone() bool {
-	// Check for a winner
-	if this.GetWinner() != None {
-		return true
+// A hash of the pieces on the board, for use as a transposition table key
+func (this State) Hash() uint64 {
+	return hashPieces(this.pieces)
+}
+
+func hashPieces(pieces [2]uint64) uint64 {
+	return mix(pieces[0]) ^ mix(pieces[1]^0x9E3779B97F4A7C15)
+}
+
+// The same game with the columns in the opposite order, including the moves
+// that were played and any that can be redone
+func (this State) Mirror() State {
+	columns := this.geometry.rules.Columns
+	mirrored := this
+	mirrored.pieces = this.mirrorPieces()
+	for col := 0; col < columns; col++ {
+		mirrored.top[col] = this.top[columns-1-col]
 	}
-	// Check if the board is full
-	full := true
-	for col := 0; col < MaxColumns; col++ {
-		full = full && this.top[col] == MaxRows
+	for i := 0; i < int(this.recorded); i++ {
+		mirrored.setHistory(i, columns-1-this.getHistory(i))
 	}
-	return full
+	return mirrored
 }
 
-// This stuff is all for checking for wins
+func (this State) mirrorPieces() [2]uint64 {
+	return [2]uint64{this.geometry.mirror(this.pieces[0]),
+		this.geometry.mirror(this.pieces[1])}
+}
 
-type pieceCounter struct {
-	count     int
-	lastPiece Piece
+// Whether the board looks the same in a mirror
+func (this State) IsSymmetric() bool {
+	return this.pieces == this.mirrorPieces()
 }
 
-func makeCounter() pieceCounter {
-	return pieceCounter{0, None}
+// A hash like Hash that's the same for a position and its mirror image
+func (this State) CanonicalHash() uint64 {
+	hash, _ := this.canonicalHash()
+	return hash
 }
 
-func (this *pieceCounter) add(piece Piece) Piece {
-	if piece != None {
-		// Count same pieces
-		if piece == this.lastPiece {
-			this.count++
-			if this.count >= 4 {
-				return piece
-			}
-			// Restart the count if not
-		} else {
-			this.count = 1
-			this.lastPiece = piece
-		}
-		// Ignore empty spaces
-	} else {
-		this.lastPiece = None
-		this.count = 0
+// Finds the canonical hash, which is the smaller of the hashes of the
+// position and its mirror image, and whether it's the mirror image's
+func (this State) canonicalHash() (uint64, bool) {
+	hash := hashPieces(this.pieces)
+	if mirrored := hashPieces(this.mirrorPieces()); mirrored < hash {
+		return mirrored, true
 	}
-	return None
+	return hash, false
 }
 
-func checkDirection(game State, col, row, cOffset, rOffset, length int) Piece {
-	cnt := makeCounter()
-	// Where do we stop?
-	lastCol := col + cOffset*length
-	lastRow := row + rOffset*length
-	// Start checking at the beginning of a possible line
-	col -= cOffset * (length - 1)
-	row -= rOffset * (length - 1)
-	for col != lastCol || row != lastRow {
-		if p := game.GetPiece(col, row); cnt.add(p) != None {
-			return p
-		}
-		col += cOffset
-		row += rOffset
+// The splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xBF58476D1CE4E5B9
+	x ^= x >> 27
+	x *= 0x94D049BB133111EB
+	x ^= x >> 31
+	return x
+}
+
+func (this State) IsDone() bool {
+	// Check for a winner
+	if this.GetWinner() != None {
+		return true
 	}
-	return None
+	// Check if the board is full
+	return this.pieces[0]|this.pieces[1] == this.geometry.fullBoard
 }
 
+// This stuff is all for checking for wins
+
 // Tests if a location lies on a winning line of pieces
 func lineTest(game State, col, row int) Piece {
-	if game.GetPiece(col, row) != None {
-		// Try ALL the directions!
-		if p := checkDirection(game, col, row, 0, 1, WinCount); p != None {
-			return p
-		} else if p := checkDirection(game, col, row, 1, 0, WinCount); p != None {
-			return p
-		} else if p := checkDirection(game, col, row, 1, 1, WinCount); p != None {
-			return p
-		} else if p := checkDirection(game, col, row, 1, -1, WinCount); p != None {
+	p := game.GetPiece(col, row)
+	if p == None {
+		return None
+	}
+	// Try ALL the lines!
+	pieces := game.pieces[p-1]
+	g := game.geometry
+	for _, line := range g.winLines[col*g.rules.Rows+row] {
+		if pieces&line.bits == line.bits {
 			return p
-		} else {
-			return None
 		}
 	}
 	return None
@@ -187,140 +287,61 @@ type Player interface {
 	NextMove(State) int
 }
 
-func RunGame(redPlayer Player, blackPlayer Player,
-	showFunc func(State), errFunc func(error), endFunc func(Piece)) {
-	game := NewState()
-	var currentColor Piece = Red
-	currentPlayer := redPlayer
-	var currentMove int
-	for {
-		showFunc(game)
-		// After a successful move
-		currentMove = currentPlayer.NextMove(game)
-		if err := game.Move(currentColor, currentMove); err == nil {
-			// Check for a game ending
-			if game.IsDone() {
-				showFunc(game)
-				endFunc(game.GetWinner())
-				break
-			}
-			// Switch players
-			if currentColor == Red {
-				currentColor = Black
-				currentPlayer = blackPlayer
-			} else {
-				currentColor = Red
-				currentPlayer = redPlayer
-			}
-		}
-	}
+// Players that can stop thinking when the game is abandoned
+type ContextPlayer interface {
+	Player
+	NextMoveContext(context.Context, State) int
 }
 
-// An artificial intelligence that runs to some depth.
-// Set depth to -1 for unlimited depth
-type AlphaBetaAI struct {
-	Color        Piece
-	Depth        int
-	EvalFunc     func(State, Piece) float64
-	TerminalTest func(State) bool
-}
-
-var colCheckOrder []int
-
-func (ai AlphaBetaAI) alphabeta(game State,
-	depth int, alpha, beta float64) float64 {
-	if depth == 0 || ai.TerminalTest(game) {
-		return ai.EvalFunc(game, ai.Color)
-	}
-	if game.GetTurn() == ai.Color {
-		for _, col := range colCheckOrder {
-			if nextState, err := game.AfterMove(game.GetTurn(), col); err == nil {
-				alpha = math.Max(
-					alpha,
-					ai.alphabeta(
-						nextState,
-						depth-1,
-						alpha,
-						beta))
-				if beta <= alpha {
-					break
-				}
-			}
-		}
-		return alpha
-	}
-	for _, col := range colCheckOrder {
-		if nextState, err := game.AfterMove(game.GetTurn(), col); err == nil {
-			beta = math.Min(
-				beta,
-				ai.alphabeta(
-					nextState,
-					depth-1,
-					alpha,
-					beta))
-			if beta <= alpha {
-				break
-			}
-		}
-	}
-	return beta
+// Players that can explain their moves, like AlphaBetaAI
+type Analyzer interface {
+	AnalyzeContext(context.Context, State) SearchResult
 }
 
-type MoveScore struct {
-	Col   int
-	Score float64
+// A Player can return TakeBack instead of a column to undo its last move,
+// along with the move its opponent made in reply
+const TakeBack = -1
+
+// Plays a game between two players, letting them retry illegal moves for as
+// long as they like. Any recorders are told about every move and the end of
+// the game.
+func RunGame(rules Rules, redPlayer Player, blackPlayer Player,
+	showFunc func(State), errFunc func(error), endFunc func(Piece),
+	recorders ...Recorder) {
+	RunGameContext(context.Background(), rules, RetryForever,
+		redPlayer, blackPlayer, showFunc, errFunc, endFunc, recorders...)
 }
 
-func (ai AlphaBetaAI) NextMove(game State) int {
-	bestMove := -1
-	bestScore := math.Inf(-1)
-	var score float64
-	scores := make(chan MoveScore)
-
-	// Initialize order to check columns
-	if len(colCheckOrder) == 0 {
-		colCheckOrder = make([]int, 0, MaxColumns)
-		col := MaxColumns / 2
-		// Alternate between above and below the center, starting at the center
-		for len(colCheckOrder) < MaxColumns {
-			colCheckOrder = append(colCheckOrder, col)
-			col -= 2*(col-MaxColumns/2) + col/(MaxColumns/2)
-		}
-	}
-
-	for col := 0; col < MaxColumns; col++ {
-		go func(col int) {
-			if nextState, err := game.AfterMove(game.GetTurn(), col); err == nil {
-				score = ai.alphabeta(
-					nextState,
-					ai.Depth-1,
-					math.Inf(-1),
-					math.Inf(+1))
-				//fmt.Println("Move %v, score %v", col, score)
-				scores <- MoveScore{col, score}
-				return
-			}
-			// Illegal moves are very bad
-			scores <- MoveScore{col, math.Inf(-1)}
-		}(col)
+// Plays a game like RunGame, abandoning it if the context is done. Illegal
+// moves are passed to errFunc and handled according to the policy. It
+// returns nil if the game is played to the end, the context's error if it's
+// abandoned that way, or the last illegal move if the policy abandons it.
+// endFunc is only called if the game has a result.
+func RunGameContext(ctx context.Context, rules Rules,
+	policy IllegalMovePolicy, redPlayer Player, blackPlayer Player,
+	showFunc func(State), errFunc func(error), endFunc func(Piece),
+	recorders ...Recorder) error {
+	m := &Match{Rules: rules, Red: redPlayer, Black: blackPlayer,
+		Policy: policy}
+	for _, r := range recorders {
+		m.Record(r)
 	}
-	for count := 0; count < MaxColumns; count++ {
-		ms := <-scores
-		if ms.Score > bestScore {
-			bestMove = ms.Col
-			bestScore = ms.Score
-			// If our heuristic isn't very smooth, add randomness to prevent
-			// prevent predictability
-		} else if ms.Score == bestScore {
-			if math.Abs(float64(ms.Col)-MaxColumns/2-0.25) <
-				math.Abs(float64(bestMove)-MaxColumns/2-0.25) {
-				bestMove = ms.Col
-			}
+	m.Subscribe(func(e Event) {
+		switch e.Kind {
+		case TurnStarted:
+			showFunc(e.Game)
+		case IllegalMove:
+			errFunc(e.Err)
+		case GameOver:
+			showFunc(e.Game)
+			endFunc(e.Result.Winner)
 		}
-	}
-	return bestMove
+	})
+	_, err := m.Play(ctx)
+	return err
 }
 
+// The weights of the original six features, which make an Evaluator
 type EvalFactors struct {
 	Win       float64
 	Lose      float64
@@ -330,32 +351,13 @@ type EvalFactors struct {
 	TheirEven float64
 }
 
-// Detects threats caused by p moving to (col, row)
-func CountThreats(game State, p Piece, col, row int) int {
-	// Empty spots don't cause threats
-	if game.GetPiece(col, row) != None {
-		return 0
-	}
-	tryLine := func(col, row, cOffset, rOffset int) int {
-		for count := 0; count < WinCount-1; count++ {
-			col += cOffset
-			row += rOffset
-			if game.GetPiece(col, row) != p {
-				if count == 3 {
-					fmt.Printf("Threat at %d, %d\n", col, row)
-				}
-				return 0
-			}
-		}
-		return 1
-	}
-	return tryLine(col, row, 1, 1) +
-		tryLine(col, row, 1, -1) +
-		tryLine(col, row, -1, -1) +
-		tryLine(col, row, -1, 1)
+func (f EvalFactors) Eval(game State, p Piece) float64 {
+	return f.evalWith(game, p, game.threatMasks(p), game.threatMasks(p.Other()))
 }
 
-func (f EvalFactors) Eval(game State, p Piece) float64 {
+// Evaluates the game given the locations of each player's threats
+func (f EvalFactors) evalWith(game State, p Piece,
+	mine, theirs [4]uint64) float64 {
 	// Winning factor
 	var win, lose float64
 	winner := game.GetWinner()
@@ -369,21 +371,16 @@ func (f EvalFactors) Eval(game State, p Piece) float64 {
 		win = 0
 		lose = 1
 	}
+	// Threats in odd and even rows, counting from 1 at the bottom, in every
+	// orientation
 	var myOddThreats, theirOddThreats float64
-	// Odd threats
-	for row := 0; row < MaxRows; row += 2 {
-		for col := 0; col < MaxColumns; col++ {
-			myOddThreats += float64(CountThreats(game, p, col, row))
-			theirOddThreats += float64(CountThreats(game, p.Other(), col, row))
-		}
-	}
-	// Even threats
 	var myEvenThreats, theirEvenThreats float64
-	for row := 1; row < MaxRows; row += 2 {
-		for col := 0; col < MaxColumns; col++ {
-			myEvenThreats += float64(CountThreats(game, p, col, row))
-			theirEvenThreats += float64(CountThreats(game, p.Other(), col, row))
-		}
+	oddRows := game.geometry.oddRows
+	for orientation := range mine {
+		myOddThreats += float64(bits.OnesCount64(mine[orientation] & oddRows))
+		myEvenThreats += float64(bits.OnesCount64(mine[orientation] &^ oddRows))
+		theirOddThreats += float64(bits.OnesCount64(theirs[orientation] & oddRows))
+		theirEvenThreats += float64(bits.OnesCount64(theirs[orientation] &^ oddRows))
 	}
 	return f.Win*win +
 		f.Lose*lose +
@@ -392,3 +389,16 @@ func (f EvalFactors) Eval(game State, p Piece) float64 {
 		f.MyOdd*myOddThreats +
 		f.TheirOdd*theirOddThreats
 }
+
+// The factors as weights for a LinearEvaluator, which scores positions the
+// same way
+func (f EvalFactors) Weights() map[string]float64 {
+	return map[string]float64{
+		"win":                f.Win,
+		"lose":               f.Lose,
+		"my-odd-threats":     f.MyOdd,
+		"their-odd-threats":  f.TheirOdd,
+		"my-even-threats":    f.MyEven,
+		"their-even-threats": f.TheirEven,
+	}
+}
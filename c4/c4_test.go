@@ -0,0 +1,297 @@
+package c4
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The board as it was kept before bitboards, with every location in an
+// array and wins found by walking the lines through the last move
+type arrayState struct {
+	rules    Rules
+	board    [MaxColumns][MaxRows]Piece
+	top      [MaxColumns]int
+	turn     Piece
+	lastMove int
+	moves    int
+}
+
+func newArrayState(rules Rules) arrayState {
+	return arrayState{rules: rules, turn: Red, lastMove: -1}
+}
+
+func (this *arrayState) move(player Piece, col int) bool {
+	if col < 0 || col >= this.rules.Columns || player != this.turn ||
+		this.top[col] >= this.rules.Rows {
+		return false
+	}
+	this.board[col][this.top[col]] = player
+	this.top[col]++
+	this.lastMove = col
+	this.turn = this.turn.Other()
+	this.moves++
+	return true
+}
+
+func (this arrayState) piece(col, row int) Piece {
+	if col < 0 || col >= this.rules.Columns ||
+		row < 0 || row >= this.rules.Rows {
+		return None
+	}
+	return this.board[col][row]
+}
+
+func (this arrayState) winner() Piece {
+	if this.lastMove < 0 {
+		return None
+	}
+	col, row := this.lastMove, this.top[this.lastMove]-1
+	p := this.board[col][row]
+	for _, d := range [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}} {
+		count := 1
+		for _, sign := range []int{1, -1} {
+			c, r := col+sign*d[0], row+sign*d[1]
+			for this.piece(c, r) == p {
+				count++
+				c, r = c+sign*d[0], r+sign*d[1]
+			}
+		}
+		if count >= this.rules.WinCount {
+			return p
+		}
+	}
+	return None
+}
+
+func (this arrayState) done() bool {
+	return this.winner() != None ||
+		this.moves == this.rules.Columns*this.rules.Rows
+}
+
+// The rule sets the tests play on
+var testRules = []Rules{
+	StandardRules,
+	{8, 7, 4},
+	{9, 7, 5},
+	{4, 4, 3},
+	{16, 4, 4},
+}
+
+// Plays random games on bitboards and arrays side by side, checking that
+// they agree after every move. Set -short to play fewer.
+func TestBitboardsMatchArrays(t *testing.T) {
+	games := 200000
+	if testing.Short() {
+		games = 5000
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, rules := range testRules {
+		for g := 0; g < games/len(testRules); g++ {
+			game, old := NewState(rules), newArrayState(rules)
+			for !old.done() {
+				col := r.Intn(rules.Columns + 1)
+				player := game.GetTurn()
+				// Now and then, try moving for the wrong player
+				if r.Intn(20) == 0 {
+					player = player.Other()
+				}
+				legal := old.move(player, col)
+				if err := game.Move(player, col); (err == nil) != legal {
+					t.Fatalf("%v %q: move %v by %v was legal %v, error %v",
+						rules, game.MoveString(), col, player, legal, err)
+				}
+				if game.GetTurn() != old.turn ||
+					game.GetWinner() != old.winner() ||
+					game.IsDone() != old.done() {
+					t.Fatalf("%v %q: turn %v winner %v done %v, "+
+						"want %v %v %v", rules, game.MoveString(),
+						game.GetTurn(), game.GetWinner(), game.IsDone(),
+						old.turn, old.winner(), old.done())
+				}
+				for c := -1; c <= rules.Columns; c++ {
+					if c >= 0 && c < rules.Columns &&
+						game.GetTop(c) != old.top[c] {
+						t.Fatalf("%v %q: top of %v is %v, want %v", rules,
+							game.MoveString(), c, game.GetTop(c), old.top[c])
+					}
+					for row := -1; row <= rules.Rows; row++ {
+						if game.GetPiece(c, row) != old.piece(c, row) {
+							t.Fatalf("%v %q: piece at %v,%v is %v, want %v",
+								rules, game.MoveString(), c, row,
+								game.GetPiece(c, row), old.piece(c, row))
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Mirror images have the same canonical hash, and mirroring twice gives
+// back the same position
+func TestMirror(t *testing.T) {
+	for _, rules := range testRules {
+		for _, game := range randomPositions(rules, 500, 4) {
+			mirrored := game.Mirror()
+			if mirrored.CanonicalHash() != game.CanonicalHash() {
+				t.Fatalf("%v %q: canonical hash %v, mirror image's %v", rules,
+					game.MoveString(), game.CanonicalHash(),
+					mirrored.CanonicalHash())
+			}
+			if back := mirrored.Mirror(); back.Hash() != game.Hash() ||
+				back.MoveString() != game.MoveString() {
+				t.Fatalf("%v %q: mirrored twice to %q", rules,
+					game.MoveString(), back.MoveString())
+			}
+			if mirrored.IsSymmetric() != game.IsSymmetric() ||
+				game.IsSymmetric() != (game.Hash() == mirrored.Hash()) {
+				t.Fatalf("%v %q: symmetric %v, mirror image %v", rules,
+					game.MoveString(), game.IsSymmetric(),
+					mirrored.IsSymmetric())
+			}
+			moves, mirroredMoves := game.Moves(), mirrored.Moves()
+			for i, col := range moves {
+				if mirroredMoves[i] != rules.Columns-1-col {
+					t.Fatalf("%v %q: mirror image's moves are %q", rules,
+						game.MoveString(), mirrored.MoveString())
+				}
+			}
+			for col := 0; col < rules.Columns; col++ {
+				if mirrored.GetTop(col) != game.GetTop(rules.Columns-1-col) {
+					t.Fatalf("%v %q: top of %v is %v in the mirror image",
+						rules, game.MoveString(), col, mirrored.GetTop(col))
+				}
+				for row := 0; row < rules.Rows; row++ {
+					if mirrored.GetPiece(col, row) !=
+						game.GetPiece(rules.Columns-1-col, row) {
+						t.Fatalf("%v %q: piece at %v,%v is %v in the "+
+							"mirror image", rules, game.MoveString(), col, row,
+							mirrored.GetPiece(col, row))
+					}
+				}
+			}
+		}
+	}
+}
+
+// The coefficients found by ga
+var testFactors = EvalFactors{
+	0.2502943943301069,
+	-0.4952316649483701,
+	0.3932539700819625,
+	-0.2742452616759889,
+	0.4746881137884282,
+	0.2091091127191147}
+
+// Positions from the middle of random games
+func randomPositions(rules Rules, count int, seed int64) []State {
+	r := rand.New(rand.NewSource(seed))
+	positions := make([]State, 0, count)
+	for len(positions) < count {
+		game := NewState(rules)
+		plies := r.Intn(rules.Columns * rules.Rows / 2)
+		for len(game.Moves()) < plies && !game.IsDone() {
+			game.Move(game.GetTurn(), r.Intn(rules.Columns))
+		}
+		if !game.IsDone() {
+			positions = append(positions, game)
+		}
+	}
+	return positions
+}
+
+// Plays and checks for wins in the same random games, on bitboards and on
+// arrays, for comparing the two
+func BenchmarkMoveAndWinner(b *testing.B) {
+	moves := randomMoves(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		game := NewState(StandardRules)
+		for _, col := range moves[i%len(moves)] {
+			game.Move(game.GetTurn(), col)
+			game.GetWinner()
+		}
+	}
+}
+
+func BenchmarkArrayMoveAndWinner(b *testing.B) {
+	moves := randomMoves(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		game := newArrayState(StandardRules)
+		for _, col := range moves[i%len(moves)] {
+			game.move(game.turn, col)
+			game.winner()
+		}
+	}
+}
+
+// The moves of random games on the standard board
+func randomMoves(count int) [][]int {
+	var games [][]int
+	for _, game := range randomPositions(StandardRules, count, 2) {
+		games = append(games, game.Moves())
+	}
+	return games
+}
+
+func BenchmarkEval(b *testing.B) {
+	positions := randomPositions(StandardRules, 1000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		testFactors.Eval(positions[i%len(positions)], Red)
+	}
+}
+
+// Searches a few positions to a fixed depth, reporting positions searched
+// per second
+func BenchmarkSearch(b *testing.B) {
+	positions := []string{"", "4453", "3443", "45345362"}
+	var nodes int64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, moves := range positions {
+			game, _ := ParseMoves(moves)
+			ai := AlphaBetaAI{
+				Color:    game.GetTurn(),
+				Depth:    7,
+				EvalFunc: testFactors.Eval,
+				TerminalTest: func(game State) bool {
+					return game.GetWinner() != None
+				},
+				Workers: 1,
+			}
+			nodes += ai.Analyze(game).Nodes
+		}
+	}
+	b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+}
+
+// Searches the same positions as BenchmarkSearch with a shared search,
+// reporting positions searched per second for each number of workers
+func BenchmarkLazySMP(b *testing.B) {
+	positions := []string{"", "4453", "3443", "45345362"}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			var nodes int64
+			for i := 0; i < b.N; i++ {
+				for _, moves := range positions {
+					game, _ := ParseMoves(moves)
+					ai := AlphaBetaAI{
+						Color:    game.GetTurn(),
+						Depth:    9,
+						EvalFunc: testFactors.Eval,
+						TerminalTest: func(game State) bool {
+							return game.GetWinner() != None
+						},
+						Workers:     workers,
+						Parallelism: LazySMP,
+					}
+					nodes += ai.Analyze(game).Nodes
+				}
+			}
+			b.ReportMetric(float64(nodes)/b.Elapsed().Seconds(), "nodes/s")
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package c4
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Scores positions from a player's point of view, for AlphaBetaAI's EvalFunc
+type Evaluator interface {
+	Eval(game State, p Piece) float64
+}
+
+// Something about a position, measured from p's point of view
+type Feature func(game State, p Piece) float64
+
+var features = struct {
+	sync.RWMutex
+	m map[string]Feature
+}{m: make(map[string]Feature)}
+
+// Makes a feature available to evaluators by name, panicking if the name is
+// taken
+func RegisterFeature(name string, f Feature) {
+	features.Lock()
+	defer features.Unlock()
+	if _, ok := features.m[name]; ok {
+		panic("c4: feature " + name + " is already registered")
+	}
+	features.m[name] = f
+}
+
+// Finds a registered feature
+func LookupFeature(name string) (Feature, bool) {
+	features.RLock()
+	defer features.RUnlock()
+	f, ok := features.m[name]
+	return f, ok
+}
+
+// The names of every registered feature, in order
+func FeatureNames() []string {
+	features.RLock()
+	defer features.RUnlock()
+	names := make([]string, 0, len(features.m))
+	for name := range features.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterFeature("win", func(game State, p Piece) float64 {
+		if game.GetWinner() == p {
+			return 1
+		}
+		return 0
+	})
+	RegisterFeature("lose", func(game State, p Piece) float64 {
+		if game.GetWinner() == p.Other() {
+			return 1
+		}
+		return 0
+	})
+	RegisterFeature("my-odd-threats", func(game State, p Piece) float64 {
+		return game.countThreats(p, game.geometry.oddRows)
+	})
+	RegisterFeature("their-odd-threats", func(game State, p Piece) float64 {
+		return game.countThreats(p.Other(), game.geometry.oddRows)
+	})
+	RegisterFeature("my-even-threats", func(game State, p Piece) float64 {
+		return game.countThreats(p, ^game.geometry.oddRows)
+	})
+	RegisterFeature("their-even-threats", func(game State, p Piece) float64 {
+		return game.countThreats(p.Other(), ^game.geometry.oddRows)
+	})
+	RegisterFeature("center", func(game State, p Piece) float64 {
+		center := game.centerColumns()
+		return float64(bits.OnesCount64(game.pieces[p-1]&center) -
+			bits.OnesCount64(game.pieces[p.Other()-1]&center))
+	})
+	RegisterFeature("my-open-twos", func(game State, p Piece) float64 {
+		return float64(game.openTwos(p))
+	})
+	RegisterFeature("their-open-twos", func(game State, p Piece) float64 {
+		return float64(game.openTwos(p.Other()))
+	})
+	RegisterFeature("mobility", func(game State, p Piece) float64 {
+		return float64(game.safeMoves(p) - game.safeMoves(p.Other()))
+	})
+	RegisterFeature("zugzwang-parity", func(game State, p Piece) float64 {
+		return float64(game.goodThreats(p) - game.goodThreats(p.Other()))
+	})
+	RegisterFeature("zugzwang", func(game State, p Piece) float64 {
+		// 1 if Allis' rules say the controller can't lose, or 2 if they
+		// say it wins, from p's point of view
+		a := AnalyzeZugzwang(game)
+		score := 0.0
+		if a.Win {
+			score = 2
+		} else if a.Refuted {
+			score = 1
+		}
+		if a.Controller != p {
+			score = -score
+		}
+		return score
+	})
+}
+
+// Counts p's threats in some locations, once for each orientation
+func (this State) countThreats(p Piece, locations uint64) float64 {
+	count := 0
+	for _, mask := range this.threatMasks(p) {
+		count += bits.OnesCount64(mask & locations)
+	}
+	return float64(count)
+}
+
+// The middle column, or the middle two on boards with an even number of
+// columns
+func (this State) centerColumns() uint64 {
+	g := this.geometry
+	column := uint64(1)<<uint(g.rules.Rows) - 1
+	center := column << uint(g.rules.Columns/2*g.rules.Rows)
+	if g.rules.Columns%2 == 0 {
+		center |= column << uint((g.rules.Columns/2-1)*g.rules.Rows)
+	}
+	return center
+}
+
+// Counts the lines holding two of p's pieces and nothing else
+func (this State) openTwos(p Piece) int {
+	mine, theirs := this.pieces[p-1], this.pieces[p.Other()-1]
+	count := 0
+	for _, line := range this.geometry.lines {
+		if line.bits&theirs == 0 && bits.OnesCount64(line.bits&mine) == 2 {
+			count++
+		}
+	}
+	return count
+}
+
+// Counts the columns p could play in without letting the other player win
+// by playing on top
+func (this State) safeMoves(p Piece) int {
+	g := this.geometry
+	var danger uint64
+	for _, mask := range this.threatMasks(p.Other()) {
+		danger |= mask
+	}
+	count := 0
+	for col := 0; col < g.rules.Columns; col++ {
+		top := int(this.top[col])
+		if top < g.rules.Rows &&
+			(top+1 == g.rules.Rows || danger&g.bit(col, top+1) == 0) {
+			count++
+		}
+	}
+	return count
+}
+
+// Counts the locations where p has threats in the rows that help it when
+// the board fills up: odd rows for red, who moves first, and even rows for
+// black
+func (this State) goodThreats(p Piece) int {
+	var threats uint64
+	for _, mask := range this.threatMasks(p) {
+		threats |= mask
+	}
+	if p == Red {
+		return bits.OnesCount64(threats & this.geometry.oddRows)
+	}
+	return bits.OnesCount64(threats &^ this.geometry.oddRows)
+}
+
+// Adds up registered features, each multiplied by its weight
+type LinearEvaluator struct {
+	names    []string
+	features []Feature
+	weights  []float64
+}
+
+// Makes a linear evaluator from the weights of features by name
+func NewLinearEvaluator(weights map[string]float64) (*LinearEvaluator, error) {
+	e := &LinearEvaluator{}
+	for name := range weights {
+		e.names = append(e.names, name)
+	}
+	sort.Strings(e.names)
+	for _, name := range e.names {
+		f, ok := LookupFeature(name)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown feature %v", name))
+		}
+		e.features = append(e.features, f)
+		e.weights = append(e.weights, weights[name])
+	}
+	return e, nil
+}
+
+func (e *LinearEvaluator) Eval(game State, p Piece) float64 {
+	score := 0.0
+	for i, f := range e.features {
+		score += e.weights[i] * f(game, p)
+	}
+	return score
+}
+
+// The names of the evaluator's features, in order
+func (e *LinearEvaluator) Names() []string {
+	return append([]string{}, e.names...)
+}
+
+// The value of each feature, in the same order as Names
+func (e *LinearEvaluator) Values(game State, p Piece) []float64 {
+	values := make([]float64, len(e.features))
+	for i, f := range e.features {
+		values[i] = f(game, p)
+	}
+	return values
+}
+
+// The weight of each feature
+func (e *LinearEvaluator) Weights() map[string]float64 {
+	weights := make(map[string]float64, len(e.names))
+	for i, name := range e.names {
+		weights[name] = e.weights[i]
+	}
+	return weights
+}
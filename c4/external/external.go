@@ -0,0 +1,337 @@
+// Package external plays moves from another program speaking the engine
+// protocol, like the one in the engine directory.
+package external
+
+import (
+	".."
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an engine has to start up and to answer when it's told to stop
+const startTimeout = 10 * time.Second
+const stopTimeout = time.Second
+
+// An engine running in another process. If it crashes or stops answering,
+// it's killed, and every move it's asked for after that is c4.MaxColumns,
+// which is never legal and isn't c4.TakeBack. Err says what went wrong, and
+// makes a c4.Match forfeit the game for the engine. An Engine can only be
+// asked for one move at a time.
+type Engine struct {
+	// Sent after "go" to start each search, like "depth 8" or
+	// "movetime 500". If it's empty, the engine gets the time left before
+	// the move's context is done, or searches to its own default depth if
+	// there's no deadline.
+	Go string
+	// How long to wait for a move when there's no deadline, or 0 to wait
+	// as long as it takes
+	MoveTimeout time.Duration
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string
+
+	mu  sync.Mutex
+	err error
+}
+
+// Starts an engine and waits until it's ready
+func Start(name string, args ...string) (*Engine, error) {
+	e := &Engine{
+		cmd:   exec.Command(name, args...),
+		lines: make(chan string, 64),
+	}
+	var err error
+	if e.stdin, err = e.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := e.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go e.read(stdout)
+
+	deadline := time.Now().Add(startTimeout)
+	e.send("uci")
+	if _, err := e.waitFor("uciok", deadline); err != nil {
+		return nil, err
+	}
+	if err := e.ready(deadline); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reads lines from the engine until it exits
+func (e *Engine) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		e.lines <- scanner.Text()
+	}
+	if err := e.cmd.Wait(); err != nil {
+		e.fail(errors.New(fmt.Sprintf("The engine exited: %v", err)))
+	} else {
+		e.fail(errors.New("The engine exited"))
+	}
+	close(e.lines)
+}
+
+// Remembers the first thing to go wrong, killing the engine
+func (e *Engine) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil {
+		e.err = err
+		e.cmd.Process.Kill()
+	}
+}
+
+// What went wrong with the engine, or nil if it's still working
+func (e *Engine) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// Writes a command to the engine. If that fails, the engine has exited or
+// soon will, which the reader reports.
+func (e *Engine) send(format string, args ...interface{}) {
+	fmt.Fprintf(e.stdin, format+"\n", args...)
+}
+
+// Waits for a line starting with a word, returning the rest of its words.
+// A zero deadline waits forever.
+func (e *Engine) waitFor(word string, deadline time.Time) ([]string, error) {
+	return e.waitForFunc(word, deadline, nil, nil)
+}
+
+// Like waitFor, but also gives up when done is closed, and passes every
+// other line to skipped
+func (e *Engine) waitForFunc(word string, deadline time.Time,
+	done <-chan struct{}, skipped func([]string)) ([]string, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return nil, e.Err()
+			}
+			fields := strings.Fields(line)
+			if len(fields) > 0 && fields[0] == word {
+				return fields[1:], nil
+			}
+			if skipped != nil {
+				skipped(fields)
+			}
+		case <-timeout:
+			err := errors.New(fmt.Sprintf(
+				"The engine didn't answer with %v in time", word))
+			e.fail(err)
+			return nil, err
+		case <-done:
+			return nil, context.Canceled
+		}
+	}
+}
+
+func (e *Engine) ready(deadline time.Time) error {
+	e.send("isready")
+	_, err := e.waitFor("readyok", deadline)
+	return err
+}
+
+// Sets one of the engine's options
+func (e *Engine) SetOption(name, value string) error {
+	if err := e.Err(); err != nil {
+		return err
+	}
+	e.send("setoption name %v value %v", name, value)
+	return e.ready(time.Now().Add(startTimeout))
+}
+
+// Starts a new game, so the engine can forget the last one
+func (e *Engine) NewGame() error {
+	if err := e.Err(); err != nil {
+		return err
+	}
+	e.send("ucinewgame")
+	return e.ready(time.Now().Add(startTimeout))
+}
+
+// Asks the engine to quit, killing it if it doesn't. Returns what went wrong
+// with the engine before it was closed, if anything.
+func (e *Engine) Close() error {
+	err := e.Err()
+	if err == nil {
+		e.send("quit")
+		// Give the engine a chance to exit by itself
+		timer := time.NewTimer(stopTimeout)
+		defer timer.Stop()
+	wait:
+		for {
+			select {
+			case _, ok := <-e.lines:
+				if !ok {
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.err = errors.New("The engine was closed")
+	e.cmd.Process.Kill()
+	return err
+}
+
+func (e *Engine) NextMove(game c4.State) int {
+	return e.NextMoveContext(context.Background(), game)
+}
+
+func (e *Engine) NextMoveContext(ctx context.Context, game c4.State) int {
+	return e.AnalyzeContext(ctx, game).Move
+}
+
+// Asks the engine for a move, keeping what it says about the search on the
+// way. The move is c4.MaxColumns if the engine couldn't give one.
+func (e *Engine) AnalyzeContext(ctx context.Context,
+	game c4.State) (result c4.SearchResult) {
+	start := time.Now()
+	result.Move = c4.MaxColumns
+	defer func() {
+		result.Elapsed = time.Since(start)
+	}()
+	if e.Err() != nil {
+		return
+	}
+
+	// Positions that were set up from a board can't be reached by their
+	// moves alone, and neither can other rules
+	pieces := 0
+	for col := 0; col < game.GetRules().Columns; col++ {
+		pieces += game.GetTop(col)
+	}
+	if game.GetRules() == c4.StandardRules && len(game.Moves()) == pieces {
+		e.send("position startpos moves %v", game.MoveString())
+	} else {
+		initial := game
+		for initial.Undo() == nil {
+		}
+		e.send("position board %v moves %v",
+			initial.BoardString(), game.MoveString())
+	}
+
+	// Leave the engine's own time out of the time it's given
+	deadline, hasDeadline := ctx.Deadline()
+	goArgs := e.Go
+	if goArgs == "" && hasDeadline {
+		left := time.Until(deadline)
+		left -= left / 10
+		goArgs = fmt.Sprintf("movetime %v", int64(left/time.Millisecond))
+	}
+	e.send("go %v", goArgs)
+
+	// The engine gets a little longer than the deadline to stop
+	if hasDeadline {
+		deadline = deadline.Add(stopTimeout)
+	} else if e.MoveTimeout > 0 {
+		deadline = time.Now().Add(e.MoveTimeout)
+	}
+	args, err := e.waitForFunc("bestmove", deadline, ctx.Done(),
+		func(info []string) {
+			parseInfo(game, info, &result)
+		})
+	if err == context.Canceled {
+		// Nobody wants the move any more, but the engine has to be ready
+		// for the next one
+		e.send("stop")
+		e.waitFor("bestmove", time.Now().Add(stopTimeout))
+		return
+	}
+	if err != nil || len(args) == 0 {
+		return
+	}
+	col, err := strconv.ParseInt(args[0], 36, 0)
+	if err != nil || col < 1 {
+		e.fail(errors.New(fmt.Sprintf("Invalid bestmove %v", args[0])))
+		return
+	}
+	result.Move = int(col) - 1
+	return
+}
+
+// Keeps the details from an info line
+func parseInfo(game c4.State, fields []string, result *c4.SearchResult) {
+	if len(fields) == 0 || fields[0] != "info" {
+		return
+	}
+	for i := 1; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "depth":
+			result.Depth, _ = strconv.Atoi(fields[i+1])
+		case "nodes":
+			result.Nodes, _ = strconv.ParseInt(fields[i+1], 10, 64)
+		case "score":
+			if i+2 >= len(fields) {
+				return
+			}
+			n, err := strconv.Atoi(fields[i+2])
+			if err != nil {
+				return
+			}
+			if fields[i+1] == "cp" {
+				result.Score = float64(n) / 100
+			} else if fields[i+1] == "mate" {
+				result.Score = mateScore(game, n)
+			}
+			i++
+		case "string":
+			return
+		case "pv":
+			result.PV = result.PV[:0]
+			for _, f := range fields[i+1:] {
+				col, err := strconv.ParseInt(f, 36, 0)
+				if err != nil {
+					break
+				}
+				result.PV = append(result.PV, int(col)-1)
+			}
+			return
+		default:
+			continue
+		}
+		i++
+	}
+}
+
+// Turns a win in some moves into a score like AlphaBetaAI's
+func mateScore(game c4.State, moves int) float64 {
+	pieces := 0
+	for col := 0; col < game.GetRules().Columns; col++ {
+		pieces += game.GetTop(col)
+	}
+	// A win in n moves is on the mover's nth piece, and a loss in n moves
+	// is on the opponent's nth
+	if moves > 0 {
+		return c4.WinScore - float64(pieces+2*moves-1)
+	}
+	return -(c4.WinScore - float64(pieces-2*moves))
+}
@@ -0,0 +1,212 @@
+package external
+
+import (
+	".."
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary runs itself as a stub engine when this is set to how the
+// engine should behave
+const stubVariable = "EXTERNAL_TEST_STUB"
+
+// Not a real test: the stub engine, which answers like this:
+//
+//	answer  Searches with "go" answer with an info line and bestmove 4
+//	crash   Searches make it exit
+//	hang    Searches are never answered, even when told to stop
+//	ponder  "go infinite" waits for stop and answers bestmove 3, and other
+//	        searches answer bestmove 5
+func TestStubEngine(t *testing.T) {
+	mode := os.Getenv(stubVariable)
+	if mode == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	searching := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name stub")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "go":
+			switch {
+			case mode == "answer":
+				fmt.Println("info depth 3 score mate 2 nodes 100 pv 4 4 5")
+				fmt.Println("bestmove 4")
+			case mode == "crash":
+				os.Exit(3)
+			case mode == "ponder" && len(fields) > 1 &&
+				fields[1] == "infinite":
+				fmt.Println("info depth 1 score cp 12 nodes 7 pv 3")
+				searching = true
+			case mode == "ponder":
+				fmt.Println("bestmove 5")
+			}
+		case "stop":
+			if searching {
+				fmt.Println("bestmove 3")
+				searching = false
+			}
+		case "quit":
+			os.Exit(0)
+		}
+	}
+	os.Exit(0)
+}
+
+// Starts the stub engine in one of its modes
+func startStub(t *testing.T, mode string) *Engine {
+	os.Setenv(stubVariable, mode)
+	defer os.Unsetenv(stubVariable)
+	e, err := Start(os.Args[0], "-test.run=^TestStubEngine$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestBestMove(t *testing.T) {
+	e := startStub(t, "answer")
+	game, _ := c4.ParseMoves("44")
+	result := e.AnalyzeContext(context.Background(), game)
+	if result.Move != 3 || result.Depth != 3 || result.Nodes != 100 {
+		t.Errorf("Got %+v, want move 3 at depth 3 with 100 nodes", result)
+	}
+	if result.Score != mateScore(game, 2) {
+		t.Errorf("Got score %v, want %v", result.Score, mateScore(game, 2))
+	}
+	if fmt.Sprint(result.PV) != "[3 3 4]" {
+		t.Errorf("Got PV %v, want [3 3 4]", result.PV)
+	}
+	if err := e.NewGame(); err != nil {
+		t.Error(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Errorf("Closing a working engine returned %v", err)
+	}
+	if e.Err() == nil {
+		t.Error("A closed engine has no error")
+	}
+}
+
+// An engine that exits loses every move after that
+func TestCrash(t *testing.T) {
+	e := startStub(t, "crash")
+	defer e.Close()
+	game := c4.NewState(c4.StandardRules)
+	if move := e.NextMove(game); move != c4.MaxColumns {
+		t.Errorf("A crashed engine played %v", move)
+	}
+	if e.Err() == nil {
+		t.Fatal("A crashed engine has no error")
+	}
+	if move := e.NextMove(game); move != c4.MaxColumns {
+		t.Errorf("After crashing, the engine played %v", move)
+	}
+	if err := e.NewGame(); err == nil {
+		t.Error("A crashed engine started a new game")
+	}
+}
+
+// An engine that doesn't answer in time is killed
+func TestMoveTimeout(t *testing.T) {
+	e := startStub(t, "hang")
+	defer e.Close()
+	e.MoveTimeout = 100 * time.Millisecond
+	game := c4.NewState(c4.StandardRules)
+	start := time.Now()
+	if move := e.NextMove(game); move != c4.MaxColumns {
+		t.Errorf("A hung engine played %v", move)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Waited %v for a hung engine", elapsed)
+	}
+	if e.Err() == nil {
+		t.Error("A hung engine has no error")
+	}
+}
+
+// Abandoning a search stops the engine and throws its move away, so it
+// doesn't get taken for the next one
+func TestCancel(t *testing.T) {
+	e := startStub(t, "ponder")
+	defer e.Close()
+	game := c4.NewState(c4.StandardRules)
+	e.Go = "infinite"
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	if move := e.NextMoveContext(ctx, game); move != c4.MaxColumns {
+		t.Errorf("An abandoned search played %v", move)
+	}
+	if err := e.Err(); err != nil {
+		t.Fatal(err)
+	}
+	e.Go = "depth 1"
+	if move := e.NextMove(game); move != 4 {
+		t.Errorf("Got %v after stopping, want 4", move)
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	game, _ := c4.ParseMoves("4")
+	cases := []struct {
+		line  string
+		depth int
+		nodes int64
+		score float64
+		pv    string
+	}{
+		{"info depth 8 score cp 39 nodes 18211 time 23 pv 4 4 5 3",
+			8, 18211, 0.39, "[3 3 4 2]"},
+		{"info depth 2 score cp -150 nodes 5", 2, 5, -1.5, "[]"},
+		{"info score mate 1 pv 4", 0, 0, mateScore(game, 1), "[3]"},
+		{"info score mate -3 depth 6", 6, 0, mateScore(game, -3), "[]"},
+		{"info depth 4 string pv 4 nodes 9", 4, 0, 0, "[]"},
+		{"bestmove 4", 0, 0, 0, "[]"},
+	}
+	for _, c := range cases {
+		var result c4.SearchResult
+		parseInfo(game, strings.Fields(c.line), &result)
+		if result.Depth != c.depth || result.Nodes != c.nodes ||
+			result.Score != c.score || fmt.Sprint(result.PV) != c.pv {
+			t.Errorf("Parsed %q as %+v", c.line, result)
+		}
+	}
+}
+
+// Mate scores are the same as AlphaBetaAI's scores for winning on that move
+func TestMateScore(t *testing.T) {
+	empty := c4.NewState(c4.StandardRules)
+	oneMove, _ := c4.ParseMoves("4")
+	cases := []struct {
+		game  c4.State
+		moves int
+		score float64
+	}{
+		// Red wins with its fourth piece, the seventh on the board
+		{empty, 4, c4.WinScore - 7},
+		{empty, 1, c4.WinScore - 1},
+		// Red's fourth piece is the ninth on the board
+		{oneMove, -4, -(c4.WinScore - 9)},
+		{oneMove, 2, c4.WinScore - 4},
+	}
+	for _, c := range cases {
+		if score := mateScore(c.game, c.moves); score != c.score {
+			t.Errorf("Mate %v after %v is %v, want %v", c.moves,
+				c.game.MoveString(), score, c.score)
+		}
+	}
+}
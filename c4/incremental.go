@@ -0,0 +1,125 @@
+package c4
+
+import (
+	"math/bits"
+)
+
+// Evaluators that can keep what they count up to date as a search plays and
+// takes back moves, instead of looking at the whole board for every
+// position
+type IncrementalEvaluator interface {
+	Evaluator
+	// Starts keeping track of a position. Each goroutine in a search has
+	// its own Incremental.
+	NewIncremental(game State) Incremental
+}
+
+// What an IncrementalEvaluator keeps up to date for one position at a time
+type Incremental interface {
+	// Called after col was played, leaving game
+	Moved(game State, col int)
+	// Called after the last move, in col, was taken back, leaving game
+	Undone(game State, col int)
+	// Scores the current position, which is game, like Evaluator.Eval
+	Eval(game State, p Piece) float64
+}
+
+// Keeps track of every player's threats, updating only the lines through
+// each location that's played or emptied
+type threatTracker struct {
+	geometry *geometry
+	occupied uint64
+	// The number of pieces of each color on each of the geometry's lines
+	counts [][2]uint8
+	// The number of lines that make each location a threat, for each
+	// player and orientation
+	lines [2][4][64]uint8
+	// The locations where each player has threats, like threatMasks
+	masks [2][4]uint64
+}
+
+func newThreatTracker(game State) *threatTracker {
+	g := game.geometry
+	t := &threatTracker{
+		geometry: g,
+		occupied: game.pieces[0] | game.pieces[1],
+		counts:   make([][2]uint8, len(g.lines)),
+	}
+	for _, line := range g.lines {
+		t.counts[line.index] = [2]uint8{
+			uint8(bits.OnesCount64(line.bits & game.pieces[0])),
+			uint8(bits.OnesCount64(line.bits & game.pieces[1]))}
+		t.addLine(line, 1)
+	}
+	return t
+}
+
+// Adds or removes the threats a line makes
+func (t *threatTracker) addLine(line winLine, delta int) {
+	gap := line.bits &^ t.occupied
+	if gap == 0 || gap&(gap-1) != 0 {
+		return
+	}
+	i := bits.TrailingZeros64(gap)
+	counts := t.counts[line.index]
+	for p := 0; p < 2; p++ {
+		if counts[1-p] != 0 {
+			continue
+		}
+		lines := &t.lines[p][line.orientation][i]
+		*lines = uint8(int(*lines) + delta)
+		if *lines != 0 {
+			t.masks[p][line.orientation] |= gap
+		} else {
+			t.masks[p][line.orientation] &^= gap
+		}
+	}
+}
+
+// Puts p's piece in a location, or takes it out if delta is -1
+func (t *threatTracker) update(col, row int, p Piece, delta int) {
+	i := col*t.geometry.rules.Rows + row
+	lines := t.geometry.winLines[i]
+	for _, line := range lines {
+		t.addLine(line, -1)
+	}
+	t.occupied ^= t.geometry.bit(col, row)
+	for _, line := range lines {
+		t.counts[line.index][p-1] = uint8(int(t.counts[line.index][p-1]) +
+			delta)
+		t.addLine(line, 1)
+	}
+}
+
+func (t *threatTracker) moved(game State, col int) {
+	t.update(col, int(game.top[col])-1, game.turn.Other(), 1)
+}
+
+func (t *threatTracker) undone(game State, col int) {
+	t.update(col, int(game.top[col]), game.turn, -1)
+}
+
+// EvalFactors kept up to date by a threatTracker
+type factorsIncremental struct {
+	factors EvalFactors
+	*threatTracker
+}
+
+// Starts keeping track of the threats in a position, so they don't have to
+// be found all over again for every position in a search. The scores are
+// exactly the same as Eval's.
+func (f EvalFactors) NewIncremental(game State) Incremental {
+	return factorsIncremental{f, newThreatTracker(game)}
+}
+
+func (f factorsIncremental) Moved(game State, col int) {
+	f.moved(game, col)
+}
+
+func (f factorsIncremental) Undone(game State, col int) {
+	f.undone(game, col)
+}
+
+func (f factorsIncremental) Eval(game State, p Piece) float64 {
+	return f.factors.evalWith(game, p, f.masks[p-1], f.masks[p.Other()-1])
+}
@@ -0,0 +1,93 @@
+package c4
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Plays and takes back random moves, checking after each that the
+// incremental scores and threats are the same as the ones found from the
+// whole board
+func TestIncrementalMatchesEval(t *testing.T) {
+	steps := 20000
+	if testing.Short() {
+		steps = 2000
+	}
+	r := rand.New(rand.NewSource(8))
+	for _, rules := range testRules {
+		for _, game := range randomPositions(rules, 20, 9) {
+			start := len(game.Moves())
+			inc := testFactors.NewIncremental(game)
+			tracker := inc.(factorsIncremental).threatTracker
+			for i := 0; i < steps; i++ {
+				// Take back a move now and then, and whenever the game is
+				// over, but never past where it started
+				if len(game.Moves()) > start &&
+					(game.IsDone() || r.Intn(3) == 0) {
+					col := game.Moves()[len(game.Moves())-1]
+					if err := game.Undo(); err != nil {
+						t.Fatal(err)
+					}
+					inc.Undone(game, col)
+				} else if !game.IsDone() {
+					col := r.Intn(rules.Columns)
+					if game.Move(game.GetTurn(), col) != nil {
+						continue
+					}
+					inc.Moved(game, col)
+				}
+				for _, p := range []Piece{Red, Black} {
+					if got, want := inc.Eval(game, p),
+						testFactors.Eval(game, p); got != want {
+						t.Fatalf("%v %q: %v scored %v, want %v", rules,
+							game.MoveString(), p, got, want)
+					}
+					if got, want := tracker.masks[p-1],
+						game.threatMasks(p); got != want {
+						t.Fatalf("%v %q: %v has threats %v, want %v", rules,
+							game.MoveString(), p, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Plays, scores and takes back every move from some positions, like a
+// search does near its leaves, scoring with Eval
+func BenchmarkFullEval(b *testing.B) {
+	positions := randomPositions(StandardRules, 1000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		game := positions[i%len(positions)]
+		for col := 0; col < StandardRules.Columns; col++ {
+			if game.Move(game.GetTurn(), col) != nil {
+				continue
+			}
+			testFactors.Eval(game, Red)
+			game.Undo()
+		}
+	}
+}
+
+// The same as BenchmarkFullEval, keeping the scores up to date instead
+func BenchmarkIncrementalEval(b *testing.B) {
+	positions := randomPositions(StandardRules, 1000, 1)
+	incs := make([]Incremental, len(positions))
+	for i, game := range positions {
+		incs[i] = testFactors.NewIncremental(game)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		game, inc := positions[i%len(positions)], incs[i%len(positions)]
+		for col := 0; col < StandardRules.Columns; col++ {
+			if game.Move(game.GetTurn(), col) != nil {
+				continue
+			}
+			inc.Moved(game, col)
+			inc.Eval(game, Red)
+			game.Undo()
+			inc.Undone(game, col)
+		}
+	}
+}
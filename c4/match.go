@@ -0,0 +1,296 @@
+package c4
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// The things that happen during a match
+const (
+	// A player is about to be asked for a move
+	TurnStarted = iota
+	// A player made a move
+	MovePlayed
+	// A player took back a move with TakeBack
+	MovesTakenBack
+	// A player tried to make an illegal move
+	IllegalMove
+	// The game has a result
+	GameOver
+)
+
+// What a match does when a player makes an illegal move. The player can try
+// again Retries times, or any number of times if Retries is negative. After
+// that, the player forfeits the game if Forfeit is set, and otherwise the
+// game is abandoned.
+type IllegalMovePolicy struct {
+	Retries int
+	Forfeit bool
+}
+
+// Lets players keep trying until they make a legal move, like people do
+var RetryForever = IllegalMovePolicy{Retries: -1}
+
+// How long players have to think. Each player starts with Base time on their
+// clock and gets Increment added after every move, or if PerMove is set,
+// gets that long for every move instead. Running out of time loses the game.
+// The zero value has no clocks at all.
+type TimeControl struct {
+	Base      time.Duration
+	Increment time.Duration
+	PerMove   time.Duration
+}
+
+// Players that can budget their time when a game has a clock
+type TimedPlayer interface {
+	// Returns the player set up to move with this much time left
+	WithClock(control TimeControl, left time.Duration) Player
+}
+
+// Players that can break, like engines running in other processes. A
+// player whose Err isn't nil after it's asked for a move forfeits the game,
+// whatever the policy says, since it can't make any more moves.
+type FalliblePlayer interface {
+	Player
+	Err() error
+}
+
+// Keeps track of games as they are played
+type Recorder interface {
+	// Called after each move with the position it was played from, along
+	// with how the player decided on it if the player is an Analyzer
+	RecordMove(game State, col int, analysis *SearchResult,
+		elapsed time.Duration)
+	// Called with the final position when the game ends
+	RecordEnd(game State, winner Piece)
+}
+
+// Something that happened during a match. Game is always the position after
+// it happened, and Player is the player it happened to, or the winner for
+// GameOver. The other fields are only set for some kinds of events.
+type Event struct {
+	Kind   int
+	Game   State
+	Player Piece
+	// The move played or tried for MovePlayed and IllegalMove
+	Column int
+	// How the player decided on the move for MovePlayed, if the match
+	// asked for an analysis and the player is an Analyzer
+	Analysis *SearchResult
+	// How long the player took for MovePlayed
+	Elapsed time.Duration
+	// What was wrong with the move for IllegalMove
+	Err error
+	// How the game ended for GameOver
+	Result *Result
+	// The time left on each player's clock, if the match has a time control.
+	// For TurnStarted, the player to move's clock starts running afterwards.
+	RedClock   time.Duration
+	BlackClock time.Duration
+}
+
+// How a match ended
+type Result struct {
+	Winner Piece // The winner, or None for a draw or an unfinished game
+	// Whether the game was played to the end, rather than being abandoned
+	Finished bool
+	// Whether the loser lost by making too many illegal moves or by
+	// breaking
+	Forfeit bool
+	// Whether the loser lost by running out of time
+	OutOfTime bool
+	Moves     []int // The moves that were played
+	Final     State // The final position
+}
+
+// A game between two players, which tells its observers about everything
+// that happens as it's played
+type Match struct {
+	Rules  Rules
+	Red    Player
+	Black  Player
+	Policy IllegalMovePolicy
+	Clock  TimeControl
+	// Moves played for the players at the start of the game, like a
+	// randomly chosen opening. They're sent to observers as MovePlayed events
+	// and can't be taken back.
+	Opening []int
+	// Whether to ask Analyzer players to explain every move
+	Analyze   bool
+	observers []func(Event)
+}
+
+// Starts a match between two players with the usual rules, where they can
+// retry illegal moves for as long as they like
+func NewMatch(red, black Player) *Match {
+	return &Match{
+		Rules:  StandardRules,
+		Red:    red,
+		Black:  black,
+		Policy: RetryForever,
+	}
+}
+
+// Adds an observer, which is called with every event in the order they
+// happen. Observers are called on the goroutine playing the match, so the
+// game waits for them.
+func (m *Match) Subscribe(observer func(Event)) {
+	m.observers = append(m.observers, observer)
+}
+
+// Has a recorder keep track of the match. Analyzer players are asked to
+// explain their moves, so it can keep their scores.
+func (m *Match) Record(r Recorder) {
+	m.Analyze = true
+	m.Subscribe(func(e Event) {
+		switch e.Kind {
+		case MovePlayed:
+			before := e.Game
+			before.Undo()
+			r.RecordMove(before, e.Column, e.Analysis, e.Elapsed)
+		case GameOver:
+			r.RecordEnd(e.Game, e.Result.Winner)
+		}
+	})
+}
+
+func (m *Match) notify(e Event) {
+	for _, observer := range m.observers {
+		observer(e)
+	}
+}
+
+// Plays the match, returning how it ended. If the context is done or the
+// policy gives up on a player, the game is abandoned and the error says why.
+func (m *Match) Play(ctx context.Context) (Result, error) {
+	game := NewState(m.Rules)
+	illegalMoves := 0
+	timed := m.Clock.Base > 0 || m.Clock.PerMove > 0
+	var clocks [2]time.Duration
+	if m.Clock.PerMove > 0 {
+		clocks = [2]time.Duration{m.Clock.PerMove, m.Clock.PerMove}
+	} else {
+		clocks = [2]time.Duration{m.Clock.Base, m.Clock.Base}
+	}
+	notify := func(e Event) {
+		e.Game = game
+		if timed {
+			e.RedClock, e.BlackClock = clocks[0], clocks[1]
+		}
+		m.notify(e)
+	}
+	end := func(result Result) Result {
+		result.Finished = true
+		result.Moves = game.Moves()
+		result.Final = game
+		notify(Event{Kind: GameOver, Player: result.Winner, Result: &result})
+		return result
+	}
+	abandon := func(err error) (Result, error) {
+		return Result{Moves: game.Moves(), Final: game}, err
+	}
+
+	for _, col := range m.Opening {
+		turn := game.GetTurn()
+		if err := game.Move(turn, col); err != nil {
+			return abandon(err)
+		}
+		notify(Event{Kind: MovePlayed, Player: turn, Column: col})
+		if game.IsDone() {
+			return end(Result{Winner: game.GetWinner()}), nil
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return abandon(err)
+		}
+		turn := game.GetTurn()
+		player := m.Red
+		if turn == Black {
+			player = m.Black
+		}
+		fallible, _ := player.(FalliblePlayer)
+		notify(Event{Kind: TurnStarted, Player: turn})
+
+		// The player's move can't take longer than its clock
+		moveCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timed {
+			if p, ok := player.(TimedPlayer); ok {
+				player = p.WithClock(m.Clock, clocks[turn-1])
+			}
+			moveCtx, cancel = context.WithTimeout(ctx, clocks[turn-1])
+		}
+		start := time.Now()
+		col, analysis := askForMove(moveCtx, player, game, m.Analyze)
+		elapsed := time.Since(start)
+		cancel()
+		// Whatever the player came up with is too late
+		if err := ctx.Err(); err != nil {
+			return abandon(err)
+		}
+		if fallible != nil && fallible.Err() != nil {
+			notify(Event{Kind: IllegalMove, Player: turn, Column: col,
+				Err: fallible.Err()})
+			return end(Result{Winner: turn.Other(), Forfeit: true}), nil
+		}
+		if timed {
+			if elapsed >= clocks[turn-1] {
+				clocks[turn-1] = 0
+				return end(Result{Winner: turn.Other(), OutOfTime: true}), nil
+			}
+			clocks[turn-1] -= elapsed
+		}
+
+		var err error
+		if col == TakeBack {
+			// The player keeps the turn
+			if int(game.played) < len(m.Opening)+2 {
+				err = errors.New("There are no moves to take back")
+			} else {
+				game.Undo()
+				game.Undo()
+				notify(Event{Kind: MovesTakenBack, Player: turn})
+			}
+		} else if err = game.Move(turn, col); err == nil {
+			if m.Clock.PerMove > 0 {
+				clocks[turn-1] = m.Clock.PerMove
+			} else {
+				clocks[turn-1] += m.Clock.Increment
+			}
+			notify(Event{Kind: MovePlayed, Player: turn, Column: col,
+				Analysis: analysis, Elapsed: elapsed})
+			if game.IsDone() {
+				return end(Result{Winner: game.GetWinner()}), nil
+			}
+		}
+
+		if err == nil {
+			illegalMoves = 0
+			continue
+		}
+		notify(Event{Kind: IllegalMove, Player: turn, Column: col, Err: err})
+		illegalMoves++
+		if m.Policy.Retries >= 0 && illegalMoves > m.Policy.Retries {
+			if !m.Policy.Forfeit {
+				return abandon(err)
+			}
+			return end(Result{Winner: turn.Other(), Forfeit: true}), nil
+		}
+	}
+}
+
+// Gets a move from a player, letting it use the context if it can. If the
+// analysis is wanted and the player is an Analyzer, that's returned too.
+func askForMove(ctx context.Context, player Player, game State,
+	analyze bool) (int, *SearchResult) {
+	if analyzer, ok := player.(Analyzer); ok && analyze {
+		result := analyzer.AnalyzeContext(ctx, game)
+		return result.Move, &result
+	}
+	if p, ok := player.(ContextPlayer); ok {
+		return p.NextMoveContext(ctx, game), nil
+	}
+	return player.NextMove(game), nil
+}
@@ -0,0 +1,254 @@
+package c4
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// A player that makes the moves it's given, in order
+type scriptedPlayer struct {
+	moves []int
+}
+
+func (this *scriptedPlayer) NextMove(game State) int {
+	move := this.moves[0]
+	this.moves = this.moves[1:]
+	return move
+}
+
+// Plays a match between two scripted players, returning the events it sent
+func playScripted(policy IllegalMovePolicy, red, black []int) (Result,
+	[]Event, error) {
+	m := NewMatch(&scriptedPlayer{red}, &scriptedPlayer{black})
+	m.Policy = policy
+	var events []Event
+	m.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+	result, err := m.Play(context.Background())
+	return result, events, err
+}
+
+// Counts the events of one kind
+func countEvents(events []Event, kind int) int {
+	count := 0
+	for _, e := range events {
+		if e.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Red wins in column 0 while Black stacks column 1
+var redWins = []int{0, 0, 0, 0}
+var blackLoses = []int{1, 1, 1}
+
+// A player gets exactly Retries more tries after an illegal move, and the
+// count starts again after a legal one
+func TestIllegalMoveRetries(t *testing.T) {
+	policy := IllegalMovePolicy{Retries: 2, Forfeit: true}
+	red := []int{MaxColumns, MaxColumns, 0, -2, 7, 0, 0, 0}
+	result, events, err := playScripted(policy, red, blackLoses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Finished || result.Forfeit || result.Winner != Red {
+		t.Errorf("Got %+v, want Red to win normally", result)
+	}
+	if count := countEvents(events, IllegalMove); count != 4 {
+		t.Errorf("Got %v illegal moves, want 4", count)
+	}
+	for _, e := range events {
+		if e.Kind == IllegalMove && (e.Player != Red || e.Err == nil) {
+			t.Errorf("Illegal move event %+v", e)
+		}
+	}
+}
+
+// Running out of retries loses the game when Forfeit is set
+func TestIllegalMoveForfeit(t *testing.T) {
+	policy := IllegalMovePolicy{Retries: 1, Forfeit: true}
+	black := []int{1, MaxColumns, 9}
+	result, events, err := playScripted(policy, redWins, black)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Finished || !result.Forfeit || result.Winner != Red {
+		t.Errorf("Got %+v, want Black to forfeit", result)
+	}
+	if len(result.Moves) != 3 || len(events) == 0 ||
+		events[len(events)-1].Kind != GameOver {
+		t.Errorf("Got moves %v and events %+v", result.Moves, events)
+	}
+}
+
+// Running out of retries abandons the game when Forfeit isn't set
+func TestIllegalMoveAbort(t *testing.T) {
+	policy := IllegalMovePolicy{Retries: 0}
+	result, events, err := playScripted(policy, []int{0, 8}, blackLoses)
+	if err == nil {
+		t.Error("Abandoned the game without an error")
+	}
+	if result.Finished || result.Winner != None || len(result.Moves) != 2 {
+		t.Errorf("Got %+v, want the game abandoned after two moves", result)
+	}
+	if count := countEvents(events, GameOver); count != 0 {
+		t.Errorf("Got %v GameOver events for an abandoned game", count)
+	}
+}
+
+// A player that cancels the match while making its move
+type cancelingPlayer struct {
+	cancel context.CancelFunc
+}
+
+func (this cancelingPlayer) NextMove(game State) int {
+	return 0
+}
+
+func (this cancelingPlayer) NextMoveContext(ctx context.Context,
+	game State) int {
+	this.cancel()
+	return 0
+}
+
+// A move that comes after the context is done isn't played, and the game is
+// abandoned with the context's error
+func TestContextAbandonsGame(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMatch(&scriptedPlayer{redWins}, cancelingPlayer{cancel})
+	result, err := m.Play(ctx)
+	if err != context.Canceled {
+		t.Errorf("Got error %v, want %v", err, context.Canceled)
+	}
+	if result.Finished || len(result.Moves) != 1 ||
+		result.Final.GetTurn() != Black {
+		t.Errorf("Got %+v, want the game abandoned on Black's turn", result)
+	}
+
+	// A context that's already done doesn't ask for any moves
+	result, err = m.Play(ctx)
+	if err != context.Canceled || result.Finished || len(result.Moves) != 0 {
+		t.Errorf("Got %+v and %v from a done context", result, err)
+	}
+}
+
+// Observers see each turn start before its move, and the end of the game
+// last
+func TestEventOrder(t *testing.T) {
+	result, events, err := playScripted(RetryForever, redWins, blackLoses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 15 {
+		t.Fatalf("Got %v events, want 15", len(events))
+	}
+	for i := 0; i < 7; i++ {
+		turn, move := Piece(Red), redWins[i/2]
+		if i%2 == 1 {
+			turn, move = Black, blackLoses[i/2]
+		}
+		started, played := events[2*i], events[2*i+1]
+		if started.Kind != TurnStarted || started.Player != turn ||
+			len(started.Game.Moves()) != i {
+			t.Errorf("Event %v is %+v, want %v's turn to start", 2*i,
+				started, turn)
+		}
+		if played.Kind != MovePlayed || played.Player != turn ||
+			played.Column != move || len(played.Game.Moves()) != i+1 {
+			t.Errorf("Event %v is %+v, want %v to play %v", 2*i+1, played,
+				turn, move)
+		}
+	}
+	end := events[14]
+	if end.Kind != GameOver || end.Player != Red || end.Result == nil ||
+		end.Result.Winner != result.Winner {
+		t.Errorf("Last event is %+v, want Red to win", end)
+	}
+}
+
+// Taking back fewer than two moves is an illegal move, and taking back two
+// gives the same player the turn again
+func TestTakeBack(t *testing.T) {
+	red := []int{TakeBack, 0, 0, TakeBack, 0, 0, 0}
+	black := []int{TakeBack, 1, 1, 1, 1}
+	result, events, err := playScripted(RetryForever, red, black)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []int
+	for _, e := range events {
+		if e.Kind != TurnStarted {
+			kinds = append(kinds, e.Kind)
+		}
+		if e.Kind == IllegalMove &&
+			e.Err.Error() != "There are no moves to take back" {
+			t.Errorf("Got error %v", e.Err)
+		}
+		if e.Kind == MovesTakenBack &&
+			(e.Player != Red || len(e.Game.Moves()) != 2) {
+			t.Errorf("Got %+v, want Red to take back to two moves", e)
+		}
+	}
+	want := []int{IllegalMove, MovePlayed, IllegalMove, MovePlayed,
+		MovePlayed, MovePlayed, MovesTakenBack, MovePlayed, MovePlayed,
+		MovePlayed, MovePlayed, MovePlayed, GameOver}
+	if len(kinds) != len(want) {
+		t.Fatalf("Got events %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("Got events %v, want %v", kinds, want)
+		}
+	}
+	if result.Winner != Red || len(result.Moves) != 7 {
+		t.Errorf("Got %+v, want Red to win in seven moves", result)
+	}
+}
+
+// A player that breaks when it runs out of moves
+type breakingPlayer struct {
+	scriptedPlayer
+	broken bool
+}
+
+func (this *breakingPlayer) NextMove(game State) int {
+	if len(this.moves) == 0 {
+		this.broken = true
+		return MaxColumns
+	}
+	return this.scriptedPlayer.NextMove(game)
+}
+
+func (this *breakingPlayer) Err() error {
+	if this.broken {
+		return errors.New("Broken")
+	}
+	return nil
+}
+
+// A broken player forfeits instead of being asked to retry forever
+func TestBrokenPlayerForfeits(t *testing.T) {
+	m := NewMatch(&scriptedPlayer{redWins},
+		&breakingPlayer{scriptedPlayer: scriptedPlayer{[]int{1}}})
+	var illegal []Event
+	m.Subscribe(func(e Event) {
+		if e.Kind == IllegalMove {
+			illegal = append(illegal, e)
+		}
+	})
+	result, err := m.Play(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Forfeit || result.Winner != Red || len(result.Moves) != 3 {
+		t.Errorf("Got %+v, want Black to forfeit after three moves", result)
+	}
+	if len(illegal) != 1 || illegal[0].Player != Black ||
+		illegal[0].Err.Error() != "Broken" {
+		t.Errorf("Got illegal moves %+v, want Black's error", illegal)
+	}
+}
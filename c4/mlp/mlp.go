@@ -0,0 +1,227 @@
+// Package mlp scores positions with a small multilayer perceptron, which
+// can be used as the evaluator for c4.AlphaBetaAI.
+//
+// The network looks at the board as two planes, one with the pieces of the
+// player to move and one with the other player's, each with a 1 for every
+// location that holds a piece. They're followed by hidden layers of
+// rectified linear units and a single tanh output, so scores are between -1
+// for a loss and 1 for a win for the player to move. A position and its
+// mirror image are both scored, and the scores averaged, so mirror images
+// score the same.
+//
+// Networks are stored as JSON, with the weights of each layer given row by
+// row, one row for each of its outputs:
+//
+//	{
+//		"Rules": {"Columns":7,"Rows":6,"WinCount":4},
+//		"Layers": [
+//			{"Weights":[[0.1,-0.2,...],...],"Biases":[0.01,...]},
+//			{"Weights":[[0.3,...]],"Biases":[0]}
+//		]
+//	}
+package mlp
+
+import (
+	".."
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+)
+
+// A fully connected layer
+type layer struct {
+	in, out int
+	// The weight from input i to output o is at o*in + i
+	weights []float64
+	biases  []float64
+}
+
+// A network for one set of rules
+type Network struct {
+	Rules  c4.Rules
+	layers []layer
+}
+
+// Makes a network with hidden layers of the given sizes and random weights
+func New(rules c4.Rules, hidden ...int) *Network {
+	n := &Network{Rules: rules}
+	in := inputs(rules)
+	for _, out := range append(hidden, 1) {
+		l := layer{in: in, out: out, weights: make([]float64, in*out),
+			biases: make([]float64, out)}
+		// Scaled so the outputs of rectified units keep their variance
+		scale := math.Sqrt(2 / float64(in))
+		for i := range l.weights {
+			l.weights[i] = rand.NormFloat64() * scale
+		}
+		n.layers = append(n.layers, l)
+		in = out
+	}
+	return n
+}
+
+// The number of inputs a network for some rules has
+func inputs(rules c4.Rules) int {
+	return 2 * rules.Columns * rules.Rows
+}
+
+// The sizes of the hidden layers
+func (n *Network) Hidden() []int {
+	hidden := make([]int, len(n.layers)-1)
+	for i := range hidden {
+		hidden[i] = n.layers[i].out
+	}
+	return hidden
+}
+
+// The locations that hold pieces, as inputs, from p's point of view. If
+// mirrored, the board is flipped from left to right first.
+func (n *Network) pieces(game c4.State, p c4.Piece, mirrored bool) []int {
+	rules := n.Rules
+	var on []int
+	for col := 0; col < rules.Columns; col++ {
+		c := col
+		if mirrored {
+			c = rules.Columns - 1 - col
+		}
+		for row := 0; row < game.GetTop(col); row++ {
+			i := c*rules.Rows + row
+			if game.GetPiece(col, row) != p {
+				i += rules.Columns * rules.Rows
+			}
+			on = append(on, i)
+		}
+	}
+	return on
+}
+
+// Works out the output of every layer for some inputs, given by which of
+// them are 1. Only the output of the last layer is squashed by tanh.
+func (n *Network) forward(on []int) [][]float64 {
+	outputs := make([][]float64, len(n.layers))
+	// Most inputs are 0, so the first layer only adds up the weights of
+	// the ones that aren't
+	first := n.layers[0]
+	out := append([]float64{}, first.biases...)
+	for o := range out {
+		row := first.weights[o*first.in : (o+1)*first.in]
+		for _, i := range on {
+			out[o] += row[i]
+		}
+	}
+	for k := range n.layers {
+		l := n.layers[k]
+		if k > 0 {
+			in := outputs[k-1]
+			out = append([]float64{}, l.biases...)
+			for o := range out {
+				row := l.weights[o*l.in : (o+1)*l.in]
+				for i, x := range in {
+					out[o] += row[i] * x
+				}
+			}
+		}
+		if k == len(n.layers)-1 {
+			for o := range out {
+				out[o] = math.Tanh(out[o])
+			}
+		} else {
+			for o := range out {
+				out[o] = math.Max(out[o], 0)
+			}
+		}
+		outputs[k] = out
+	}
+	return outputs
+}
+
+// Scores a position from p's point of view, between -1 and 1. The game has
+// to use the network's rules.
+func (n *Network) Eval(game c4.State, p c4.Piece) float64 {
+	if game.GetRules() != n.Rules {
+		panic("mlp: the game doesn't use the network's rules")
+	}
+	score := n.score(game)
+	if p != game.GetTurn() {
+		score = -score
+	}
+	return score
+}
+
+// Scores a position from the point of view of the player to move
+func (n *Network) score(game c4.State) float64 {
+	score := 0.0
+	for _, mirrored := range []bool{false, true} {
+		outputs := n.forward(n.pieces(game, game.GetTurn(), mirrored))
+		score += outputs[len(outputs)-1][0]
+	}
+	return score / 2
+}
+
+// How a layer is stored
+type layerFile struct {
+	Weights [][]float64
+	Biases  []float64
+}
+
+// How a network is stored
+type networkFile struct {
+	Rules  c4.Rules
+	Layers []layerFile
+}
+
+// Writes the network as JSON
+func (n *Network) Write(w io.Writer) error {
+	file := networkFile{Rules: n.Rules}
+	for _, l := range n.layers {
+		lf := layerFile{Biases: l.biases}
+		for o := 0; o < l.out; o++ {
+			lf.Weights = append(lf.Weights, l.weights[o*l.in:(o+1)*l.in])
+		}
+		file.Layers = append(file.Layers, lf)
+	}
+	return json.NewEncoder(w).Encode(file)
+}
+
+// Reads a network written by Write
+func Read(r io.Reader) (*Network, error) {
+	var file networkFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	if err := file.Rules.Validate(); err != nil {
+		return nil, err
+	}
+	if len(file.Layers) == 0 {
+		return nil, errors.New("The network has no layers")
+	}
+	n := &Network{Rules: file.Rules}
+	in := inputs(file.Rules)
+	for k, lf := range file.Layers {
+		out := len(lf.Biases)
+		if out == 0 || len(lf.Weights) != out {
+			return nil, errors.New(fmt.Sprintf(
+				"Layer %v has %v rows of weights for %v biases", k+1,
+				len(lf.Weights), out))
+		}
+		l := layer{in: in, out: out, biases: lf.Biases}
+		for _, row := range lf.Weights {
+			if len(row) != in {
+				return nil, errors.New(fmt.Sprintf(
+					"Layer %v needs %v weights in each row, not %v", k+1,
+					in, len(row)))
+			}
+			l.weights = append(l.weights, row...)
+		}
+		n.layers = append(n.layers, l)
+		in = out
+	}
+	if in != 1 {
+		return nil, errors.New(fmt.Sprintf(
+			"The last layer has %v outputs instead of 1", in))
+	}
+	return n, nil
+}
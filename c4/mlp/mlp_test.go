@@ -0,0 +1,133 @@
+package mlp
+
+import (
+	".."
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var testRules = c4.Rules{Columns: 5, Rows: 4, WinCount: 3}
+
+// Positions reached by random moves, none of them finished
+func randomGames(rules c4.Rules, count int, seed int64) []c4.State {
+	r := rand.New(rand.NewSource(seed))
+	var games []c4.State
+	for len(games) < count {
+		game := c4.NewState(rules)
+		plies := r.Intn(rules.Columns * rules.Rows)
+		for i := 0; i < plies && !game.IsDone(); i++ {
+			game.Move(game.GetTurn(), r.Intn(rules.Columns))
+		}
+		if !game.IsDone() {
+			games = append(games, game)
+		}
+	}
+	return games
+}
+
+// Mirror images score the same, and each player's score is the other's
+// negated
+func TestEvalSymmetry(t *testing.T) {
+	n := New(testRules, 8, 4)
+	for _, game := range randomGames(testRules, 200, 1) {
+		score := n.Eval(game, c4.Red)
+		mirrored := n.Eval(game.Mirror(), c4.Red)
+		if math.Abs(mirrored-score) > 1e-12 {
+			t.Errorf("%q scores %v, its mirror image %v", game.MoveString(),
+				score, mirrored)
+		}
+		if black := n.Eval(game, c4.Black); black != -score {
+			t.Errorf("%q scores %v for red and %v for black",
+				game.MoveString(), score, black)
+		}
+		if score <= -1 || score >= 1 {
+			t.Errorf("%q scores %v", game.MoveString(), score)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	n := New(testRules, 6, 3)
+	var buf bytes.Buffer
+	if err := n.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Rules != n.Rules || len(read.Hidden()) != 2 ||
+		read.Hidden()[0] != 6 || read.Hidden()[1] != 3 {
+		t.Fatalf("Read a network for %v with hidden layers %v", read.Rules,
+			read.Hidden())
+	}
+	for _, game := range randomGames(testRules, 50, 2) {
+		if a, b := n.Eval(game, c4.Red), read.Eval(game, c4.Red); a != b {
+			t.Errorf("%q scores %v, and %v after reading", game.MoveString(),
+				a, b)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	rules := `"Rules":{"Columns":2,"Rows":1,"WinCount":1}`
+	for _, file := range []string{
+		`{` + rules + `,"Layers":[]}`,
+		`{` + rules + `,"Layers":[{"Weights":[[1,2]],"Biases":[0,0]}]}`,
+		`{` + rules + `,"Layers":[{"Weights":[[1,2,3]],"Biases":[0]}]}`,
+		`{` + rules + `,"Layers":[{"Weights":[[1,2,3,4],[1,2,3,4]],` +
+			`"Biases":[0,0]}]}`,
+		`{"Rules":{"Columns":0,"Rows":1,"WinCount":1},` +
+			`"Layers":[{"Weights":[[]],"Biases":[0]}]}`,
+		`{` + rules,
+	} {
+		if _, err := Read(bytes.NewBufferString(file)); err == nil {
+			t.Errorf("Read %v", file)
+		}
+	}
+	file := `{` + rules + `,"Layers":[{"Weights":[[1,2,3,4]],"Biases":[0]}]}`
+	if _, err := Read(bytes.NewBufferString(file)); err != nil {
+		t.Errorf("Reading %v: %v", file, err)
+	}
+}
+
+// The gradient is the same as the one found by nudging each weight
+func TestGradient(t *testing.T) {
+	n := New(testRules, 5, 3)
+	// Units with no inputs would sit right on the kink of the rectifier,
+	// where nudging a bias only counts on one side
+	r := rand.New(rand.NewSource(4))
+	for _, l := range n.layers {
+		for o := range l.biases {
+			l.biases[o] = r.NormFloat64() / 10
+		}
+	}
+	for i, game := range randomGames(testRules, 5, 3) {
+		e := Example{game, float64(i%3) - 1}
+		gradient := n.zeros()
+		loss := n.addGradient(e, gradient)
+		if want := n.Loss([]Example{e}); math.Abs(loss-want) > 1e-12 {
+			t.Errorf("%q: loss %v, want %v", game.MoveString(), loss, want)
+		}
+
+		const h = 1e-6
+		g := parameters(gradient)
+		for j, w := range parameters(n.layers) {
+			for k := range w {
+				old := w[k]
+				w[k] = old + h
+				up := n.Loss([]Example{e})
+				w[k] = old - h
+				down := n.Loss([]Example{e})
+				w[k] = old
+				want := (up - down) / (2 * h)
+				if math.Abs(g[j][k]-want) > 1e-6 {
+					t.Fatalf("%q: gradient %v of parameters %v is %v, "+
+						"want %v", game.MoveString(), k, j, g[j][k], want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package mlp
+
+import (
+	".."
+	"math"
+)
+
+// The ways a Trainer can follow the gradient
+const (
+	// Stochastic gradient descent with momentum
+	SGD = iota
+	// Adam, which scales each weight's steps by how big its gradients have
+	// been
+	Adam
+)
+
+// A position with the score the network should give it, from the point of
+// view of the player to move
+type Example struct {
+	Game   c4.State
+	Target float64
+}
+
+// Fits a network to examples, a batch at a time, minimizing the mean of
+// half the squared differences between the scores and the targets
+type Trainer struct {
+	Network   *Network
+	Optimizer int
+	// How big the steps are
+	Rate float64
+	// For SGD, how much of the last step is kept in the next
+	Momentum float64
+	// For Adam, how quickly the averages of the gradients and of their
+	// squares forget old ones
+	Beta1, Beta2 float64
+
+	steps int
+	// The gradient, and the averages the optimizers keep, in the shape of
+	// the network's layers
+	gradient, m, v []layer
+}
+
+// Makes a trainer with the usual settings for an optimizer
+func NewTrainer(n *Network, optimizer int) *Trainer {
+	t := &Trainer{Network: n, Optimizer: optimizer, Rate: 0.01,
+		Momentum: 0.9, Beta1: 0.9, Beta2: 0.999}
+	if optimizer == Adam {
+		t.Rate = 0.001
+	}
+	t.gradient = n.zeros()
+	t.m = n.zeros()
+	t.v = n.zeros()
+	return t
+}
+
+// Layers the same shape as the network's, full of zeros
+func (n *Network) zeros() []layer {
+	layers := make([]layer, len(n.layers))
+	for k, l := range n.layers {
+		layers[k] = layer{in: l.in, out: l.out,
+			weights: make([]float64, len(l.weights)),
+			biases:  make([]float64, len(l.biases))}
+	}
+	return layers
+}
+
+// Every weight and bias of some layers, a slice at a time, in the same
+// order for layers of the same shape
+func parameters(layers []layer) [][]float64 {
+	var params [][]float64
+	for _, l := range layers {
+		params = append(params, l.weights, l.biases)
+	}
+	return params
+}
+
+// Takes one step towards fitting a batch of examples, returning the loss
+// before the step
+func (t *Trainer) Train(batch []Example) float64 {
+	n := t.Network
+	for _, g := range parameters(t.gradient) {
+		for i := range g {
+			g[i] = 0
+		}
+	}
+	loss := 0.0
+	for _, e := range batch {
+		loss += n.addGradient(e, t.gradient)
+	}
+	if len(batch) == 0 {
+		return 0
+	}
+	scale := 1 / float64(len(batch))
+
+	t.steps++
+	m, v := parameters(t.m), parameters(t.v)
+	for j, w := range parameters(n.layers) {
+		g := parameters(t.gradient)[j]
+		for i := range w {
+			grad := g[i] * scale
+			switch t.Optimizer {
+			case Adam:
+				m[j][i] = t.Beta1*m[j][i] + (1-t.Beta1)*grad
+				v[j][i] = t.Beta2*v[j][i] + (1-t.Beta2)*grad*grad
+				// The averages start at zero, so early on they're
+				// scaled up to make up for it
+				mHat := m[j][i] / (1 - math.Pow(t.Beta1, float64(t.steps)))
+				vHat := v[j][i] / (1 - math.Pow(t.Beta2, float64(t.steps)))
+				w[i] -= t.Rate * mHat / (math.Sqrt(vHat) + 1e-8)
+			default:
+				m[j][i] = t.Momentum*m[j][i] - t.Rate*grad
+				w[i] += m[j][i]
+			}
+		}
+	}
+	return loss * scale
+}
+
+// The mean loss over some examples
+func (n *Network) Loss(examples []Example) float64 {
+	if len(examples) == 0 {
+		return 0
+	}
+	loss := 0.0
+	for _, e := range examples {
+		d := n.score(e.Game) - e.Target
+		loss += d * d / 2
+	}
+	return loss / float64(len(examples))
+}
+
+// Adds the gradient of an example's loss to gradient, returning the loss
+func (n *Network) addGradient(e Example, gradient []layer) float64 {
+	type pass struct {
+		on      []int
+		outputs [][]float64
+	}
+	var passes [2]pass
+	score := 0.0
+	for j, mirrored := range []bool{false, true} {
+		on := n.pieces(e.Game, e.Game.GetTurn(), mirrored)
+		passes[j] = pass{on, n.forward(on)}
+		score += passes[j].outputs[len(n.layers)-1][0] / 2
+	}
+	d := score - e.Target
+
+	// The score is the mean of the two passes, so each gets half the
+	// gradient
+	for _, p := range passes {
+		last := len(n.layers) - 1
+		out := p.outputs[last][0]
+		delta := []float64{d / 2 * (1 - out*out)}
+		for k := last; k >= 0; k-- {
+			l, g := n.layers[k], gradient[k]
+			for o, dout := range delta {
+				g.biases[o] += dout
+			}
+			if k == 0 {
+				for o, dout := range delta {
+					row := g.weights[o*l.in : (o+1)*l.in]
+					for _, i := range p.on {
+						row[i] += dout
+					}
+				}
+				break
+			}
+			in := p.outputs[k-1]
+			prev := make([]float64, l.in)
+			for o, dout := range delta {
+				row := g.weights[o*l.in : (o+1)*l.in]
+				weights := l.weights[o*l.in : (o+1)*l.in]
+				for i, x := range in {
+					row[i] += dout * x
+					prev[i] += dout * weights[i]
+				}
+			}
+			// Rectified units only pass on the gradient when they're on
+			for i, x := range in {
+				if x <= 0 {
+					prev[i] = 0
+				}
+			}
+			delta = prev
+		}
+	}
+	return d * d / 2
+}
@@ -0,0 +1,146 @@
+package c4
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Positions can be written down in two ways.
+//
+// A move string lists the columns played, numbered from 1, so "4453" is two
+// pieces in the middle column followed by one on either side of it. This is
+// the notation most Connect Four solvers use. Columns past 9 are written with
+// letters, starting from a.
+//
+// A board string lists the rows from the top down, separated by slashes, with
+// R for red, B for black and . for an empty location, followed by a space and
+// whose turn it is. It can describe any position where no piece is floating,
+// even one that no order of moves could reach, so the empty standard board is
+//
+//	......./......./......./......./......./....... R
+
+// Plays a move string on the standard board
+func ParseMoves(moves string) (State, error) {
+	return StandardRules.ParseMoves(moves)
+}
+
+// Plays a move string on a board with these rules
+func (rules Rules) ParseMoves(moves string) (State, error) {
+	game := NewState(rules)
+	for i, c := range moves {
+		col, err := strconv.ParseInt(string(c), 36, 0)
+		if err != nil || col < 1 {
+			return game, errors.New(fmt.Sprintf(
+				"Invalid column %q at move %v", c, i+1))
+		}
+		if game.IsDone() {
+			return game, errors.New(fmt.Sprintf(
+				"The game is already over at move %v", i+1))
+		}
+		if err := game.Move(game.GetTurn(), int(col)-1); err != nil {
+			return game, err
+		}
+	}
+	return game, nil
+}
+
+// The move string for the moves played since the start of the game or the
+// board it was set up from
+func (this State) MoveString() string {
+	var s []byte
+	for _, col := range this.Moves() {
+		s = strconv.AppendInt(s, int64(col+1), 36)
+	}
+	return string(s)
+}
+
+// Sets up a board string on the standard board
+func ParseBoard(board string) (State, error) {
+	return StandardRules.ParseBoard(board)
+}
+
+// Sets up a board string on a board with these rules
+func (rules Rules) ParseBoard(board string) (State, error) {
+	game := NewState(rules)
+	fields := strings.Fields(board)
+	if len(fields) != 2 {
+		return game, errors.New("A board needs its rows and whose turn it is")
+	}
+	rows := strings.Split(fields[0], "/")
+	if len(rows) != rules.Rows {
+		return game, errors.New(fmt.Sprintf(
+			"The board has %v rows instead of %v", len(rows), rules.Rows))
+	}
+	for i, line := range rows {
+		row := rules.Rows - i - 1
+		if len(line) != rules.Columns {
+			return game, errors.New(fmt.Sprintf(
+				"Row %v has %v columns instead of %v",
+				row, len(line), rules.Columns))
+		}
+		for col := 0; col < rules.Columns; col++ {
+			var p Piece
+			switch line[col] {
+			case 'R':
+				p = Red
+			case 'B':
+				p = Black
+			case '.':
+				// Nothing above an empty location can hold a piece
+				if int(game.top[col]) > row+1 {
+					return game, errors.New(fmt.Sprintf(
+						"The piece above %v, %v is floating", col, row))
+				}
+				continue
+			default:
+				return game, errors.New(fmt.Sprintf(
+					"Invalid piece %q at %v, %v", line[col], col, row))
+			}
+			// Rows are read from the top, so the first piece found in a
+			// column is its highest one
+			if game.top[col] == 0 {
+				game.top[col] = uint8(row + 1)
+			}
+			game.pieces[p-1] |= game.geometry.bit(col, row)
+		}
+	}
+	switch fields[1] {
+	case "R":
+		game.turn = Red
+	case "B":
+		game.turn = Black
+	default:
+		return game, errors.New(fmt.Sprintf(
+			"Invalid turn %q", fields[1]))
+	}
+	return game, nil
+}
+
+// The board string for the position
+func (this State) BoardString() string {
+	rules := this.geometry.rules
+	var s []byte
+	for row := rules.Rows - 1; row >= 0; row-- {
+		for col := 0; col < rules.Columns; col++ {
+			switch this.GetPiece(col, row) {
+			case Red:
+				s = append(s, 'R')
+			case Black:
+				s = append(s, 'B')
+			default:
+				s = append(s, '.')
+			}
+		}
+		if row > 0 {
+			s = append(s, '/')
+		}
+	}
+	if this.turn == Red {
+		s = append(s, " R"...)
+	} else {
+		s = append(s, " B"...)
+	}
+	return string(s)
+}
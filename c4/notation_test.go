@@ -0,0 +1,85 @@
+package c4
+
+import (
+	"testing"
+)
+
+// Move strings and board strings read back as the positions they were
+// written from, on every rule set
+func TestNotationRoundTrip(t *testing.T) {
+	for _, rules := range testRules {
+		for _, game := range randomPositions(rules, 500, 3) {
+			moves := game.MoveString()
+			parsed, err := rules.ParseMoves(moves)
+			if err != nil {
+				t.Fatalf("%v %q: %v", rules, moves, err)
+			}
+			if parsed.MoveString() != moves || parsed.Hash() != game.Hash() ||
+				parsed.GetTurn() != game.GetTurn() {
+				t.Fatalf("%v %q: read back as %q", rules, moves,
+					parsed.MoveString())
+			}
+
+			board := game.BoardString()
+			parsed, err = rules.ParseBoard(board)
+			if err != nil {
+				t.Fatalf("%v %q: %v", rules, board, err)
+			}
+			if parsed.BoardString() != board || parsed.Hash() != game.Hash() ||
+				parsed.GetTurn() != game.GetTurn() {
+				t.Fatalf("%v %q: read back as %q", rules, board,
+					parsed.BoardString())
+			}
+			for col := 0; col < rules.Columns; col++ {
+				if parsed.GetTop(col) != game.GetTop(col) {
+					t.Fatalf("%v %q: top of %v is %v, want %v", rules, board,
+						col, parsed.GetTop(col), game.GetTop(col))
+				}
+			}
+		}
+	}
+}
+
+func TestParseMovesErrors(t *testing.T) {
+	for _, moves := range []string{
+		// Columns that aren't on the board
+		"0",
+		"448",
+		"44-",
+		"4 4",
+		// A full column
+		"4444444",
+		// Moves after red has won
+		"12121211",
+	} {
+		if _, err := ParseMoves(moves); err == nil {
+			t.Errorf("%q was read without an error", moves)
+		}
+	}
+}
+
+func TestParseBoardErrors(t *testing.T) {
+	for _, board := range []string{
+		// No turn, or too much after it
+		"......./......./......./......./......./.......",
+		"......./......./......./......./......./....... R B",
+		// A turn that isn't a player
+		"......./......./......./......./......./....... X",
+		"......./......./......./......./......./....... .",
+		// Too few or too many rows
+		"......./......./......./......./....... R",
+		"......./......./......./......./......./......./....... R",
+		// Rows that are too short or too long
+		"......./......./......./......./....../....... R",
+		"......./......./......./......./......./........ R",
+		// Pieces that aren't red or black
+		"......./......./......./......./......./...X... R",
+		// Floating pieces
+		"......./......./......./......./...R.../....... R",
+		"R....../B....../......./R....../B....../R...... B",
+	} {
+		if _, err := ParseBoard(board); err == nil {
+			t.Errorf("%q was read without an error", board)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package record
+
+import (
+	".."
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006.01.02"
+const timeFormat = "15:04:05"
+
+// Reads records one after another
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// Gets the next line, or io.EOF if there are none left
+func (r *Reader) next() (string, error) {
+	if !r.s.Scan() {
+		if err := r.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	r.line++
+	return strings.TrimSpace(r.s.Text()), nil
+}
+
+func (r *Reader) errorf(format string, args ...interface{}) error {
+	return errors.New(fmt.Sprintf("Line %v: ", r.line) +
+		fmt.Sprintf(format, args...))
+}
+
+// Reads the next record, or returns io.EOF if there are none left
+func (r *Reader) Read() (*Record, error) {
+	rec := &Record{Rules: c4.StandardRules}
+	line, err := r.next()
+	// Skip to the start of the record
+	for err == nil && line == "" {
+		line, err = r.next()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Tags
+	var date, clock, result string
+	for ; err == nil && strings.HasPrefix(line, "["); line, err = r.next() {
+		if !strings.HasSuffix(line, "]") {
+			return nil, r.errorf("Unterminated tag")
+		}
+		fields := strings.SplitN(line[1:len(line)-1], " ", 2)
+		if len(fields) != 2 {
+			return nil, r.errorf("Tag without a value")
+		}
+		value, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return nil, r.errorf("Invalid tag value %v", fields[1])
+		}
+		switch fields[0] {
+		case "Event":
+			rec.Event = value
+		case "Date":
+			date = value
+		case "Time":
+			clock = value
+		case "Columns":
+			rec.Rules.Columns, err = strconv.Atoi(value)
+		case "Rows":
+			rec.Rules.Rows, err = strconv.Atoi(value)
+		case "WinCount":
+			rec.Rules.WinCount, err = strconv.Atoi(value)
+		case "Red":
+			rec.Red.Name = value
+		case "RedDepth":
+			rec.Red.Depth, err = strconv.Atoi(value)
+		case "RedCoefficients":
+			rec.Red.Coefficients, err = parseCoefficients(value)
+		case "RedWeights":
+			rec.Red.Weights, err = parseWeights(value)
+		case "Black":
+			rec.Black.Name = value
+		case "BlackDepth":
+			rec.Black.Depth, err = strconv.Atoi(value)
+		case "BlackCoefficients":
+			rec.Black.Coefficients, err = parseCoefficients(value)
+		case "BlackWeights":
+			rec.Black.Weights, err = parseWeights(value)
+		case "Result":
+			result = value
+		}
+		// Other tags are ignored, like in PGN
+		if err != nil {
+			return nil, r.errorf("Invalid %v tag: %v", fields[0], err)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := rec.Rules.Validate(); err != nil {
+		return nil, r.errorf("%v", err)
+	}
+	if date != "" {
+		if clock == "" {
+			clock = "00:00:00"
+		}
+		rec.Date, err = time.Parse(dateFormat+" "+timeFormat, date+" "+clock)
+		if err != nil {
+			return nil, r.errorf("Invalid date: %v", err)
+		}
+	}
+
+	// Moves, up to the next blank line
+	for err == nil && line == "" {
+		line, err = r.next()
+	}
+	var text []string
+	for ; err == nil && line != ""; line, err = r.next() {
+		text = append(text, line)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := rec.parseMoves(strings.Join(text, " ")); err != nil {
+		return nil, r.errorf("%v", err)
+	}
+	if result != "" && result != rec.Result() {
+		return nil, r.errorf("The result is %v in the tags but %v after "+
+			"the moves", result, rec.Result())
+	}
+	return rec, nil
+}
+
+func (rec *Record) parseMoves(text string) error {
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimSpace(text) {
+		// Comments go with the move before them
+		if text[0] == '{' {
+			end := strings.IndexByte(text, '}')
+			if end < 0 {
+				return errors.New("Unterminated comment")
+			}
+			if len(rec.Moves) == 0 {
+				return errors.New("Comment before the first move")
+			}
+			if err := rec.Moves[len(rec.Moves)-1].parseComment(
+				text[1:end]); err != nil {
+				return err
+			}
+			text = text[end+1:]
+			continue
+		}
+		end := strings.IndexAny(text, " {")
+		if end < 0 {
+			end = len(text)
+		}
+		token := text[:end]
+		text = text[end:]
+		// The result ends the moves
+		if err := rec.setResult(token); err == nil {
+			if strings.TrimSpace(text) != "" {
+				return errors.New("Moves after the result")
+			}
+			return nil
+		}
+		// Move numbers are just there for people
+		if strings.HasSuffix(token, ".") {
+			if _, err := strconv.Atoi(token[:len(token)-1]); err != nil {
+				return errors.New(fmt.Sprintf("Invalid move number %q", token))
+			}
+			continue
+		}
+		col, err := strconv.ParseInt(token, 36, 0)
+		if err != nil || col < 1 || int(col) > rec.Rules.Columns {
+			return errors.New(fmt.Sprintf("Invalid move %q", token))
+		}
+		rec.Moves = append(rec.Moves, Move{Column: int(col) - 1})
+	}
+	return errors.New("Missing result")
+}
+
+func (m *Move) parseComment(comment string) error {
+	fields := strings.Fields(comment)
+	for i := 0; i+1 < len(fields); i += 2 {
+		var err error
+		switch fields[i] {
+		case "eval":
+			m.Score, err = strconv.ParseFloat(fields[i+1], 64)
+			m.Scored = true
+		case "time":
+			m.Time, err = time.ParseDuration(fields[i+1])
+		}
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"Invalid %v in comment: %v", fields[i], err))
+		}
+	}
+	return nil
+}
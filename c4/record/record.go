@@ -0,0 +1,194 @@
+// Package record reads and writes game records, which are modelled on the
+// Portable Game Notation used for chess. A record starts with tags giving
+// the players, the rules and the result, followed by the moves:
+//
+//	[Event "ga generation 3"]
+//	[Date "2026.10.16"]
+//	[Time "14:02:11"]
+//	[Columns "7"]
+//	[Rows "6"]
+//	[WinCount "4"]
+//	[Red "AlphaBetaAI"]
+//	[RedDepth "8"]
+//	[RedCoefficients "0.25 -0.49 0.39 -0.27 0.47 0.20"]
+//	[Black "Linear"]
+//	[BlackWeights "center=0.1 lose=-0.5 win=0.25"]
+//	[Result "0-1"]
+//
+//	1. 4 {eval 0.39 time 1.2s} 4 {time 5.1s} 2. 5 {eval 0.78 time 0.9s} 3
+//	...
+//	0-1
+//
+// Columns are numbered from 1 like in c4.ParseMoves. The comment after a move
+// holds the player's score for it, if it had one, and how long it took.
+// Results are 1-0 when red wins, 0-1 when black wins, 1/2-1/2 for a draw and
+// * for a game that didn't finish, and the Result tag has to agree with the
+// result after the moves. Records in a file are separated by blank lines.
+package record
+
+import (
+	".."
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One of the players in a game
+type Player struct {
+	Name         string
+	Depth        int       // How far an AI searched, or 0
+	Coefficients []float64 // The weights of an AI's evaluator, if any
+	// The weights of an AI's evaluator by feature name, for evaluators that
+	// aren't c4.EvalFactors
+	Weights map[string]float64
+}
+
+// A move and how the player came to it
+type Move struct {
+	Column int
+	Score  float64 // The player's score for the move, if Scored
+	Scored bool
+	Time   time.Duration
+}
+
+// A whole game
+type Record struct {
+	Event    string
+	Date     time.Time
+	Rules    c4.Rules
+	Red      Player
+	Black    Player
+	Finished bool
+	Winner   c4.Piece // The winner of a finished game, or c4.None for a draw
+	Moves    []Move
+}
+
+// Starts a record of a game that's about to be played
+func New(rules c4.Rules, red, black Player) *Record {
+	return &Record{
+		Date:  time.Now(),
+		Rules: rules,
+		Red:   red,
+		Black: black,
+	}
+}
+
+// Adds a move to the record as a match plays it. Any moves after the
+// position it was played from have been taken back, so they're dropped.
+func (rec *Record) RecordMove(game c4.State, col int,
+	analysis *c4.SearchResult, elapsed time.Duration) {
+	if played := len(game.Moves()); played < len(rec.Moves) {
+		rec.Moves = rec.Moves[:played]
+	}
+	move := Move{Column: col, Time: elapsed}
+	if analysis != nil {
+		move.Score = analysis.Score
+		move.Scored = true
+	}
+	rec.Moves = append(rec.Moves, move)
+}
+
+// Records the end of the game as a match finishes it
+func (rec *Record) RecordEnd(game c4.State, winner c4.Piece) {
+	rec.Finished = true
+	rec.Winner = winner
+}
+
+// The move string for the game, as used by c4.ParseMoves
+func (rec *Record) MoveString() string {
+	var s []byte
+	for _, m := range rec.Moves {
+		s = strconv.AppendInt(s, int64(m.Column+1), 36)
+	}
+	return string(s)
+}
+
+// Replays the game to get its final position
+func (rec *Record) State() (c4.State, error) {
+	return rec.Rules.ParseMoves(rec.MoveString())
+}
+
+// The result as it's written in a record
+func (rec *Record) Result() string {
+	if !rec.Finished {
+		return "*"
+	}
+	switch rec.Winner {
+	case c4.Red:
+		return "1-0"
+	case c4.Black:
+		return "0-1"
+	}
+	return "1/2-1/2"
+}
+
+// Sets the result from the way it's written in a record
+func (rec *Record) setResult(result string) error {
+	switch result {
+	case "1-0":
+		rec.Finished, rec.Winner = true, c4.Red
+	case "0-1":
+		rec.Finished, rec.Winner = true, c4.Black
+	case "1/2-1/2":
+		rec.Finished, rec.Winner = true, c4.None
+	case "*":
+		rec.Finished, rec.Winner = false, c4.None
+	default:
+		return errors.New(fmt.Sprintf("Invalid result %q", result))
+	}
+	return nil
+}
+
+func formatCoefficients(coeffs []float64) string {
+	fields := make([]string, len(coeffs))
+	for i, c := range coeffs {
+		fields[i] = strconv.FormatFloat(c, 'g', -1, 64)
+	}
+	return strings.Join(fields, " ")
+}
+
+// Writes weights as name=weight, in order of their names
+func formatWeights(weights map[string]float64) string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = name + "=" +
+			strconv.FormatFloat(weights[name], 'g', -1, 64)
+	}
+	return strings.Join(fields, " ")
+}
+
+func parseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, f := range strings.Fields(s) {
+		i := strings.LastIndex(f, "=")
+		if i < 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid weight %q", f))
+		}
+		w, err := strconv.ParseFloat(f[i+1:], 64)
+		if err != nil {
+			return nil, err
+		}
+		weights[f[:i]] = w
+	}
+	return weights, nil
+}
+
+func parseCoefficients(s string) ([]float64, error) {
+	fields := strings.Fields(s)
+	coeffs := make([]float64, len(fields))
+	for i, f := range fields {
+		var err error
+		if coeffs[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return nil, err
+		}
+	}
+	return coeffs, nil
+}
@@ -0,0 +1,127 @@
+package record
+
+import (
+	".."
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records with every kind of tag, long games and all four results
+func testRecords() []*Record {
+	long := &Record{
+		Event: "ga generation 3",
+		Date:  time.Date(2026, 10, 16, 14, 2, 11, 0, time.UTC),
+		Rules: c4.StandardRules,
+		Red: Player{Name: "AlphaBetaAI", Depth: 8,
+			Coefficients: []float64{0.25, -0.49, 0.39, -0.27, 0.47, 0.2}},
+		Black: Player{Name: "Linear", Depth: 6,
+			Weights: map[string]float64{"center": 0.1, "lose": -0.5,
+				"win": 0.25}},
+		Finished: true,
+		Winner:   c4.Black,
+	}
+	// Enough moves with comments to need several lines
+	for i, col := range []int{3, 3, 4, 4, 2, 2, 1, 5, 5, 1, 0, 0, 6, 6, 6,
+		6, 0, 0, 1, 1} {
+		m := Move{Column: col, Time: time.Duration(i+1) * 123 * time.Millisecond}
+		if i%3 != 0 {
+			m.Score, m.Scored = float64(i)*0.125-1, true
+		}
+		long.Moves = append(long.Moves, m)
+	}
+	wide := c4.Rules{Columns: 16, Rows: 4, WinCount: 4}
+	return []*Record{
+		long,
+		{Event: "Red wins", Rules: c4.StandardRules,
+			Red: Player{Name: "Red"}, Black: Player{Name: "Black"},
+			Finished: true, Winner: c4.Red,
+			Moves: []Move{{Column: 0}, {Column: 1}, {Column: 0},
+				{Column: 1}, {Column: 0}, {Column: 1}, {Column: 0}}},
+		{Event: "Draw", Rules: wide, Finished: true,
+			Moves: []Move{{Column: 15, Time: time.Second}}},
+		{Event: "Unfinished", Rules: wide,
+			Moves: []Move{{Column: 10, Score: -1e9, Scored: true}}},
+		{Event: "No moves", Rules: c4.StandardRules},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	records := testRecords()
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) > lineLength {
+			t.Errorf("Line %q is longer than %v", line, lineLength)
+		}
+	}
+	written := buf.String()
+
+	r := NewReader(&buf)
+	for _, want := range records {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("%v: %v", want.Event, err)
+		}
+		if !got.Date.Equal(want.Date) {
+			t.Errorf("%v: date %v, want %v", want.Event, got.Date, want.Date)
+		}
+		got.Date = want.Date
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Read %+v, want %+v", got, want)
+		}
+	}
+	if rec, err := r.Read(); err != io.EOF {
+		t.Errorf("Read %+v %v after the last record", rec, err)
+	}
+	if !strings.Contains(written, "[Result \"0-1\"]") ||
+		!strings.Contains(written, "{eval -0.875 time 246ms}") {
+		t.Errorf("Wrote %v", written)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tags := "[Event \"x\"]\n[Result \"1-0\"]\n\n"
+	for _, record := range []string{
+		// Move text
+		tags + "1. 4 x 1-0",
+		tags + "1. 8 1-0",
+		tags + "1. 0 1-0",
+		tags + "one. 4 1-0",
+		tags + "1. 4 {time 1s 1-0",
+		tags + "{time 1s} 1. 4 1-0",
+		tags + "1. 4 {time soon} 1-0",
+		tags + "1. 4 {eval high} 1-0",
+		tags + "1. 4 4",
+		tags + "1. 4 1-0 5",
+		tags + "1. 4 2-0",
+		// Tags
+		"[Event \"x\"\n\n1. 4 *",
+		"[Event]\n\n1. 4 *",
+		"[Event x]\n\n1. 4 *",
+		"[Columns \"seven\"]\n\n1. 4 *",
+		"[Columns \"0\"]\n\n1. 4 *",
+		"[WinCount \"0\"]\n\n1. 4 *",
+		"[Rows \"10\"]\n\n1. 4 *",
+		"[RedDepth \"deep\"]\n\n1. 4 *",
+		"[RedCoefficients \"0.1 x\"]\n\n1. 4 *",
+		"[BlackWeights \"win\"]\n\n1. 4 *",
+		"[Date \"2026-10-16\"]\n\n1. 4 *",
+		"[Date \"2026.10.16\"]\n[Time \"noon\"]\n\n1. 4 *",
+		// The tag and the moves disagree
+		"[Result \"1-0\"]\n\n1. 4 0-1",
+		"[Result \"1/2-1/2\"]\n\n1. 4 *",
+	} {
+		if rec, err := NewReader(strings.NewReader(record)).Read(); err == nil {
+			t.Errorf("%q was read as %+v", record, rec)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Records are wrapped to fit in a terminal
+const lineLength = 79
+
+// Writes records one after another
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w}
+}
+
+func (w *Writer) Write(rec *Record) error {
+	var buf bytes.Buffer
+	tag := func(name, value string) {
+		fmt.Fprintf(&buf, "[%s %s]\n", name, strconv.Quote(value))
+	}
+	player := func(color string, p Player) {
+		tag(color, p.Name)
+		if p.Depth != 0 {
+			tag(color+"Depth", strconv.Itoa(p.Depth))
+		}
+		if p.Coefficients != nil {
+			tag(color+"Coefficients", formatCoefficients(p.Coefficients))
+		}
+		if p.Weights != nil {
+			tag(color+"Weights", formatWeights(p.Weights))
+		}
+	}
+
+	// Tags
+	tag("Event", rec.Event)
+	if !rec.Date.IsZero() {
+		// Dates are kept in UTC, so they read back the same anywhere
+		tag("Date", rec.Date.UTC().Format(dateFormat))
+		tag("Time", rec.Date.UTC().Format(timeFormat))
+	}
+	tag("Columns", strconv.Itoa(rec.Rules.Columns))
+	tag("Rows", strconv.Itoa(rec.Rules.Rows))
+	tag("WinCount", strconv.Itoa(rec.Rules.WinCount))
+	player("Red", rec.Red)
+	player("Black", rec.Black)
+	tag("Result", rec.Result())
+	buf.WriteByte('\n')
+
+	// Moves, wrapped without breaking up comments
+	lineLen := 0
+	word := func(s string) {
+		if lineLen > 0 && lineLen+1+len(s) > lineLength {
+			buf.WriteByte('\n')
+			lineLen = 0
+		} else if lineLen > 0 {
+			buf.WriteByte(' ')
+			lineLen++
+		}
+		buf.WriteString(s)
+		lineLen += len(s)
+	}
+	for i, m := range rec.Moves {
+		if i%2 == 0 {
+			word(strconv.Itoa(i/2+1) + ".")
+		}
+		word(strconv.FormatInt(int64(m.Column+1), 36))
+		comment := "{"
+		if m.Scored {
+			comment += "eval " + strconv.FormatFloat(m.Score, 'g', -1, 64) + " "
+		}
+		comment += "time " + m.Time.Round(time.Millisecond).String() + "}"
+		word(comment)
+	}
+	word(rec.Result())
+	buf.WriteString("\n\n")
+
+	_, err := w.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,145 @@
+package c4
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// The size of a board and the length of the line needed to win
+type Rules struct {
+	Columns  int
+	Rows     int
+	WinCount int
+}
+
+// Connect Four as it's usually played
+var StandardRules = Rules{7, 6, 4}
+
+// Checks that a board can be played with these rules. Every location has to
+// fit in a bitboard, so there can be at most 64 of them.
+func (r Rules) Validate() error {
+	if r.Columns < 1 || r.Columns > MaxColumns {
+		return errors.New(fmt.Sprintf(
+			"There must be between 1 and %v columns, not %v",
+			MaxColumns, r.Columns))
+	}
+	if r.Rows < 1 || r.Rows > MaxRows {
+		return errors.New(fmt.Sprintf(
+			"There must be between 1 and %v rows, not %v", MaxRows, r.Rows))
+	}
+	if r.Columns*r.Rows > 64 {
+		return errors.New(fmt.Sprintf(
+			"A %vx%v board has more than 64 locations", r.Columns, r.Rows))
+	}
+	if r.WinCount < 1 {
+		return errors.New(fmt.Sprintf(
+			"Lines must be at least 1 long, not %v", r.WinCount))
+	}
+	return nil
+}
+
+// A line of locations, which way it goes and where it is in the geometry's
+// lines
+type winLine struct {
+	bits        uint64
+	orientation int
+	index       int
+}
+
+// What every state needs to know about its rules, worked out once
+type geometry struct {
+	rules Rules
+	// Every location on the board
+	fullBoard uint64
+	// All the lines of WinCount locations that pass through each location
+	winLines [][]winLine
+	// Every line of WinCount locations
+	lines []winLine
+	// The locations in odd rows, counting from 1 at the bottom
+	oddRows uint64
+	// The order to check columns, from the center out
+	colOrder []int
+}
+
+var geometries = struct {
+	sync.Mutex
+	m map[Rules]*geometry
+}{m: make(map[Rules]*geometry)}
+
+// Finds the geometry for some rules, working it out the first time
+func geometryFor(rules Rules) *geometry {
+	geometries.Lock()
+	defer geometries.Unlock()
+	if g, ok := geometries.m[rules]; ok {
+		return g
+	}
+	g := &geometry{
+		rules:     rules,
+		fullBoard: 1<<uint(rules.Columns*rules.Rows) - 1,
+		winLines:  make([][]winLine, rules.Columns*rules.Rows),
+		colOrder:  make([]int, rules.Columns),
+	}
+	if rules.Columns*rules.Rows == 64 {
+		g.fullBoard = ^uint64(0)
+	}
+
+	// In the order of the orientations
+	directions := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	for col := 0; col < rules.Columns; col++ {
+		for row := 0; row < rules.Rows; row++ {
+			if row%2 == 0 {
+				g.oddRows |= g.bit(col, row)
+			}
+			for orientation, d := range directions {
+				line := uint64(0)
+				c, r := col, row
+				for count := 0; count < rules.WinCount; count++ {
+					if c < 0 || c >= rules.Columns || r < 0 || r >= rules.Rows {
+						line = 0
+						break
+					}
+					line |= g.bit(c, r)
+					c += d[0]
+					r += d[1]
+				}
+				// Lines of one location go in every direction at once
+				if line == 0 || rules.WinCount == 1 && d != directions[0] {
+					continue
+				}
+				wl := winLine{line, orientation, len(g.lines)}
+				g.lines = append(g.lines, wl)
+				// Add the line to every location on it
+				for l := line; l != 0; l &= l - 1 {
+					i := bits.TrailingZeros64(l)
+					g.winLines[i] = append(g.winLines[i], wl)
+				}
+			}
+		}
+	}
+
+	// Alternate between above and below the center, starting at the center
+	for i := range g.colOrder {
+		g.colOrder[i] = rules.Columns/2 + (1-2*(i%2))*(i+1)/2
+	}
+
+	geometries.m[rules] = g
+	return g
+}
+
+// The bit for each location
+func (g *geometry) bit(col, row int) uint64 {
+	return 1 << uint(col*g.rules.Rows+row)
+}
+
+// Flips a bitboard from left to right
+func (g *geometry) mirror(b uint64) (m uint64) {
+	rows := uint(g.rules.Rows)
+	column := uint64(1)<<rows - 1
+	for col := 0; col < g.rules.Columns; col++ {
+		m |= (b >> (uint(col) * rows) & column) <<
+			(uint(g.rules.Columns-1-col) * rows)
+	}
+	return
+}
@@ -0,0 +1,158 @@
+package solver
+
+import (
+	".."
+	"math/bits"
+)
+
+// The size of the standard board
+const width = 7
+const height = 6
+
+// The smallest and largest scores a position can have
+const minScore = -(width*height)/2 + 3
+const maxScore = (width*height+1)/2 - 3
+
+// A position on a bitboard with a sentinel bit above each column. The bit
+// for a location is col*(height+1) + row.
+type position struct {
+	current uint64 // The pieces of the player to move
+	mask    uint64 // Every piece
+	moves   int
+}
+
+func bottomMask(col int) uint64 {
+	return 1 << uint(col*(height+1))
+}
+
+func topMask(col int) uint64 {
+	return 1 << uint(height-1+col*(height+1))
+}
+
+func columnMask(col int) uint64 {
+	return (1<<height - 1) << uint(col*(height+1))
+}
+
+// The bottom and every location of the board
+var bottom, board uint64
+
+func init() {
+	for col := 0; col < width; col++ {
+		bottom |= bottomMask(col)
+		board |= columnMask(col)
+	}
+}
+
+// Copies a position from a game, which must use the standard rules
+func fromState(game c4.State) (p position) {
+	if game.GetRules() != c4.StandardRules {
+		panic("solver: only games with the standard rules can be solved")
+	}
+	turn := game.GetTurn()
+	for col := 0; col < width; col++ {
+		for row := 0; row < game.GetTop(col); row++ {
+			b := uint64(1) << uint(col*(height+1)+row)
+			p.mask |= b
+			if game.GetPiece(col, row) == turn {
+				p.current |= b
+			}
+			p.moves++
+		}
+	}
+	return
+}
+
+// A unique key for the position, which is the same for its mirror image.
+// Keys fit in 49 bits.
+func (p position) key() uint64 {
+	key := p.current + p.mask
+	if mirrored := mirror(key); mirrored < key {
+		return mirrored
+	}
+	return key
+}
+
+// Flips a bitboard from left to right
+func mirror(b uint64) (m uint64) {
+	for col := 0; col < width; col++ {
+		m |= (b >> uint(col*(height+1)) & (1<<(height+1) - 1)) <<
+			uint((width-1-col)*(height+1))
+	}
+	return
+}
+
+func (p position) canPlay(col int) bool {
+	return p.mask&topMask(col) == 0
+}
+
+// Plays a move, given as the bit where the piece lands
+func (p *position) play(move uint64) {
+	p.current ^= p.mask
+	p.mask |= move
+	p.moves++
+}
+
+func (p *position) playCol(col int) {
+	p.play((p.mask + bottomMask(col)) & columnMask(col))
+}
+
+// Where pieces can be played
+func (p position) possible() uint64 {
+	return (p.mask + bottom) & board
+}
+
+// Where the player to move would win
+func (p position) winningPositions() uint64 {
+	return winningPositions(p.current, p.mask)
+}
+
+// Where the other player would win
+func (p position) opponentWinningPositions() uint64 {
+	return winningPositions(p.current^p.mask, p.mask)
+}
+
+func (p position) canWinNext() bool {
+	return p.winningPositions()&p.possible() != 0
+}
+
+func (p position) isWinningMove(col int) bool {
+	return p.winningPositions()&p.possible()&columnMask(col) != 0
+}
+
+// The moves that don't let the other player win on their next move. This
+// assumes that the player to move can't win immediately.
+func (p position) possibleNonLosingMoves() uint64 {
+	possible := p.possible()
+	threats := p.opponentWinningPositions()
+	forced := possible & threats
+	if forced != 0 {
+		// Two threats at once can't both be blocked
+		if forced&(forced-1) != 0 {
+			return 0
+		}
+		possible = forced
+	}
+	// Don't play below a threat
+	return possible &^ (threats >> 1)
+}
+
+// How good a move looks, measured by the threats it makes
+func (p position) moveScore(move uint64) int {
+	return bits.OnesCount64(winningPositions(p.current|move, p.mask))
+}
+
+// Finds the empty locations that would complete a line of the given pieces
+func winningPositions(pieces, mask uint64) uint64 {
+	// Vertical
+	r := (pieces << 1) & (pieces << 2) & (pieces << 3)
+	// The other directions, which need a piece on either side checked
+	for _, shift := range []uint{height + 1, height, height + 2} {
+		p := (pieces << shift) & (pieces << (2 * shift))
+		r |= p & (pieces << (3 * shift))
+		r |= p & (pieces >> shift)
+		p = (pieces >> shift) & (pieces >> (2 * shift))
+		r |= p & (pieces << shift)
+		r |= p & (pieces >> (3 * shift))
+	}
+	return r & (board ^ mask)
+}
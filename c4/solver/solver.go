@@ -0,0 +1,262 @@
+// Package solver plays Connect Four perfectly on the standard board.
+//
+// Positions are searched to the end of the game by negamax with null-window
+// searches, bitboards, a transposition table and moves ordered by the
+// threats they make. Scores are from the point of view of the player to
+// move: positive if they can force a win, negative if they will lose
+// against perfect play and zero for a draw. Quicker wins have larger scores.
+//
+// Solving the empty board searches about a billion positions, which takes
+// a few minutes.
+package solver
+
+import (
+	".."
+	"math"
+)
+
+// The game-theoretic value of a position
+type Result struct {
+	// The score as described above, which is the number of pieces the
+	// winner has left to play, counting the winning piece
+	Score int
+	// The player who wins with perfect play, or None for a draw
+	Winner c4.Piece
+	// The number of moves left in the game with perfect play, where the
+	// winner wins as quickly as they can and the loser holds out
+	Moves int
+}
+
+// Finds the exact value of positions on the standard board, panicking if
+// given any other. A Solver remembers what it has
+// searched between calls, but can't be used by more than one goroutine
+// at a time.
+type Solver struct {
+	table *table
+	// The number of positions searched
+	Nodes int64
+}
+
+// Makes a new solver, with a transposition table of 128 MB
+func New() *Solver {
+	return &Solver{table: newTable()}
+}
+
+// Forgets every searched position
+func (s *Solver) Reset() {
+	s.table.clear()
+	s.Nodes = 0
+}
+
+// The order to try columns in, from the center out
+var columnOrder = func() (order [width]int) {
+	for i := range order {
+		order[i] = width/2 + (1-2*(i%2))*(i+1)/2
+	}
+	return
+}()
+
+// Moves sorted by score, where the best move is taken first and ties go to
+// the last move added
+type moveSorter struct {
+	size    int
+	entries [width]struct {
+		move  uint64
+		score int
+	}
+}
+
+func (m *moveSorter) add(move uint64, score int) {
+	pos := m.size
+	m.size++
+	for ; pos > 0 && m.entries[pos-1].score > score; pos-- {
+		m.entries[pos] = m.entries[pos-1]
+	}
+	m.entries[pos].move = move
+	m.entries[pos].score = score
+}
+
+func (m *moveSorter) next() uint64 {
+	if m.size == 0 {
+		return 0
+	}
+	m.size--
+	return m.entries[m.size].move
+}
+
+// Scores a position within the window (alpha, beta). The player to move
+// must not be able to win immediately. Scores outside the window are only
+// bounds on the true score.
+func (s *Solver) negamax(p position, alpha, beta int) int {
+	s.Nodes++
+
+	next := p.possibleNonLosingMoves()
+	// Every move lets the other player win
+	if next == 0 {
+		return -(width*height - p.moves) / 2
+	}
+	// Neither player can win before the board fills up
+	if p.moves >= width*height-2 {
+		return 0
+	}
+
+	// We can't win in the next move, and the other player can't either,
+	// so the score is bounded
+	min := -(width*height - 2 - p.moves) / 2
+	if alpha < min {
+		alpha = min
+		if alpha >= beta {
+			return alpha
+		}
+	}
+	max := (width*height - 1 - p.moves) / 2
+	key := p.key()
+	if value := int(s.table.get(key)); value != 0 {
+		if value > maxScore-minScore+1 {
+			// A lower bound
+			min = value + 2*minScore - maxScore - 2
+			if alpha < min {
+				alpha = min
+				if alpha >= beta {
+					return alpha
+				}
+			}
+		} else {
+			// An upper bound
+			max = value + minScore - 1
+		}
+	}
+	if beta > max {
+		beta = max
+		if alpha >= beta {
+			return beta
+		}
+	}
+
+	// Try the moves that make the most threats first. The edges are added
+	// first, so that ties go to the center.
+	var moves moveSorter
+	for i := width - 1; i >= 0; i-- {
+		if move := next & columnMask(columnOrder[i]); move != 0 {
+			moves.add(move, p.moveScore(move))
+		}
+	}
+	for move := moves.next(); move != 0; move = moves.next() {
+		nextPos := p
+		nextPos.play(move)
+		score := -s.negamax(nextPos, -beta, -alpha)
+		if score >= beta {
+			s.table.put(key, p.moves, uint8(score+maxScore-2*minScore+2))
+			return score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	s.table.put(key, p.moves, uint8(alpha-minScore+1))
+	return alpha
+}
+
+// Finds the score of a position by narrowing its bounds with null-window
+// searches
+func (s *Solver) solve(p position) int {
+	if p.canWinNext() {
+		return (width*height + 1 - p.moves) / 2
+	}
+	min := -(width*height - p.moves) / 2
+	max := (width*height + 1 - p.moves) / 2
+	for min < max {
+		med := min + (max-min)/2
+		// Look for quick results first
+		if med <= 0 && min/2 < med {
+			med = min / 2
+		} else if med >= 0 && max/2 > med {
+			med = max / 2
+		}
+		if r := s.negamax(p, med, med+1); r <= med {
+			max = r
+		} else {
+			min = r
+		}
+	}
+	return min
+}
+
+// Finds the value of a game
+func (s *Solver) Solve(game c4.State) Result {
+	p := fromState(game)
+	turn := game.GetTurn()
+	if game.GetWinner() != c4.None {
+		// The last move won
+		return makeResult(-(width*height+2-p.moves)/2, turn, p.moves)
+	}
+	if p.moves == width*height {
+		return makeResult(0, turn, p.moves)
+	}
+	return makeResult(s.solve(p), turn, p.moves)
+}
+
+// Works out the winner and length of a game from its score
+func makeResult(score int, turn c4.Piece, played int) Result {
+	result := Result{Score: score}
+	if score == 0 {
+		result.Moves = width*height - played
+		return result
+	}
+	// The winner's last move is played after (width*height + 1 - 2*score)
+	// or one more moves, whichever leaves it on their turn
+	winner := turn
+	if score < 0 {
+		winner = turn.Other()
+		score = -score
+	}
+	before := width*height + 1 - 2*score
+	if (before-played)%2 != 0 {
+		if winner == turn {
+			before--
+		}
+	} else if winner != turn {
+		before--
+	}
+	result.Winner = winner
+	result.Moves = before - played + 1
+	return result
+}
+
+// Scores each legal move, from the point of view of the player making it.
+// Illegal moves are scored as math.MinInt32.
+func (s *Solver) ScoreMoves(game c4.State) (scores [width]int) {
+	p := fromState(game)
+	for col := 0; col < width; col++ {
+		if !p.canPlay(col) {
+			scores[col] = math.MinInt32
+		} else if p.isWinningMove(col) {
+			scores[col] = (width*height + 1 - p.moves) / 2
+		} else {
+			next := p
+			next.playCol(col)
+			scores[col] = -s.solve(next)
+		}
+	}
+	return
+}
+
+// Plays the quickest win there is, or the longest loss, preferring the
+// center when moves are equally good. If there are no legal moves, this
+// returns c4.MaxColumns, which is never legal.
+func (s *Solver) NextMove(game c4.State) int {
+	if game.IsDone() {
+		return c4.MaxColumns
+	}
+	scores := s.ScoreMoves(game)
+	best := c4.MaxColumns
+	for _, col := range columnOrder {
+		if scores[col] == math.MinInt32 {
+			continue
+		}
+		if best == c4.MaxColumns || scores[col] > scores[best] {
+			best = col
+		}
+	}
+	return best
+}
@@ -0,0 +1,150 @@
+package solver
+
+import (
+	".."
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Positions from the last part of random games that aren't over yet
+func latePositions(count, minPlies int, seed int64) []c4.State {
+	r := rand.New(rand.NewSource(seed))
+	var positions []c4.State
+	for len(positions) < count {
+		game := c4.NewState(c4.StandardRules)
+		plies := minPlies + r.Intn(width*height-minPlies)
+		for len(game.Moves()) < plies && !game.IsDone() {
+			game.Move(game.GetTurn(), r.Intn(width))
+		}
+		if !game.IsDone() {
+			positions = append(positions, game)
+		}
+	}
+	return positions
+}
+
+// The score an AlphaBetaAI searching to the end of the game should give a
+// result, from the point of view of the player to move
+func aiScore(game c4.State, result Result) float64 {
+	if result.Winner == c4.None {
+		return 0
+	}
+	score := c4.WinScore - float64(len(game.Moves())+result.Moves)
+	if result.Winner != game.GetTurn() {
+		return -score
+	}
+	return score
+}
+
+// Solving agrees with searching to the end of the game
+func TestSolveMatchesSearch(t *testing.T) {
+	count := 100
+	if testing.Short() {
+		count = 20
+	}
+	s := New()
+	for _, game := range latePositions(count, 24, 1) {
+		result := s.Solve(game)
+		ai := c4.AlphaBetaAI{
+			Color: game.GetTurn(),
+			Depth: -1,
+			EvalFunc: func(c4.State, c4.Piece) float64 {
+				return 0
+			},
+			TerminalTest: func(game c4.State) bool {
+				return game.GetWinner() != c4.None
+			},
+			Table:   c4.NewTranspositionTable(16),
+			Workers: 1,
+		}
+		want := ai.Analyze(game)
+		if got := aiScore(game, result); got != want.Score {
+			t.Fatalf("%q: solved as %+v, which scores %v, want %v",
+				game.MoveString(), result, got, want.Score)
+		}
+
+		// The move played is one of the best, and keeps the result
+		col := s.NextMove(game)
+		scores := s.ScoreMoves(game)
+		for c, score := range scores {
+			if score > scores[col] {
+				t.Fatalf("%q: played %v scoring %v, but %v scores %v",
+					game.MoveString(), col, scores[col], c, score)
+			}
+		}
+		next, err := game.AfterMove(game.GetTurn(), col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after := s.Solve(next); after.Winner != result.Winner ||
+			after.Moves != result.Moves-1 {
+			t.Fatalf("%q: after playing %v, solved as %+v, not %+v",
+				game.MoveString(), col, after, result)
+		}
+	}
+}
+
+func TestSolveKnownPositions(t *testing.T) {
+	s := New()
+	for _, test := range []struct {
+		moves  string
+		winner c4.Piece
+		left   int
+	}{
+		// Red wins at once down the first column
+		{"121212", c4.Red, 1},
+		// Red has three along the bottom with both ends open, so black
+		// blocks one end and red wins at the other
+		{"44553", c4.Red, 2},
+	} {
+		game, err := c4.ParseMoves(test.moves)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := s.Solve(game)
+		if result.Winner != test.winner || result.Moves != test.left {
+			t.Errorf("%q: solved as %+v, want %v winning in %v moves",
+				test.moves, result, test.winner, test.left)
+		}
+	}
+}
+
+// The first player wins on the empty board, with its last piece
+func TestSolveEmptyBoard(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Solving the empty board takes minutes")
+	}
+	result := New().Solve(c4.NewState(c4.StandardRules))
+	if result.Winner != c4.Red || result.Score != 1 ||
+		result.Moves != width*height-1 {
+		t.Errorf("Solved as %+v, want red to win with its last piece",
+			result)
+	}
+}
+
+func TestNoMoves(t *testing.T) {
+	s := New()
+	// Red has won down the first column
+	won, err := c4.ParseMoves("1212121")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := s.Solve(won); result.Winner != c4.Red || result.Moves != 0 {
+		t.Errorf("Solved a won game as %+v", result)
+	}
+	if col := s.NextMove(won); col != c4.MaxColumns {
+		t.Errorf("Played %v after the game was won", col)
+	}
+	// With only one column left, that's the one to play
+	game := latePositions(1, 41, 2)[0]
+	if col := s.NextMove(game); !game.IsLegal(game.GetTurn(), col) {
+		t.Errorf("%q: played %v", game.MoveString(), col)
+	}
+	scores := s.ScoreMoves(game)
+	for col, score := range scores {
+		if (score == math.MinInt32) == game.IsLegal(game.GetTurn(), col) {
+			t.Errorf("%q: scored %v as %v", game.MoveString(), col, score)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package solver
+
+// The table has 2^tableBits entries, taking 128 MB
+const tableBits = 24
+
+// A transposition table of bounds on scores. Entries come in pairs that
+// share a cache line. The first of each pair keeps whichever position is
+// closest to the start of the game, since those took the most work to
+// search, and the second takes everything else.
+//
+// Each entry holds a 49-bit key, the number of moves played in 7 bits and
+// the value in the top byte, where a value of zero means empty.
+type table struct {
+	entries []uint64
+}
+
+func newTable() *table {
+	return &table{entries: make([]uint64, 1<<tableBits)}
+}
+
+// The bits of an entry holding the key
+const keyMask = 1<<49 - 1
+
+// Finds the first entry of the pair for a key with a Fibonacci hash
+func (t *table) index(key uint64) uint64 {
+	return (key * 0x9E3779B97F4A7C15) >> (64 - tableBits) &^ 1
+}
+
+func (t *table) put(key uint64, moves int, value uint8) {
+	i := t.index(key)
+	entry := key | uint64(moves)<<49 | uint64(value)<<56
+	if first := t.entries[i]; first == 0 || first&keyMask == key ||
+		int(first>>49&0x7F) >= moves {
+		t.entries[i] = entry
+	} else {
+		t.entries[i+1] = entry
+	}
+}
+
+func (t *table) get(key uint64) uint8 {
+	i := t.index(key)
+	if entry := t.entries[i]; entry&keyMask == key {
+		return uint8(entry >> 56)
+	}
+	if entry := t.entries[i+1]; entry&keyMask == key {
+		return uint8(entry >> 56)
+	}
+	return 0
+}
+
+func (t *table) clear() {
+	for i := range t.entries {
+		t.entries[i] = 0
+	}
+}
@@ -0,0 +1,116 @@
+package c4
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Kinds of scores kept in a transposition table
+const (
+	exactBound = iota
+	lowerBound
+	upperBound
+)
+
+// A single slot in a transposition table. The words are read and written
+// separately, so check holds the key XORed with the other two words, which
+// lets torn reads from concurrent writers be detected and ignored.
+type tableSlot struct {
+	check uint64
+	score uint64
+	data  uint64
+}
+
+// What was learned about a position from an earlier search
+type tableEntry struct {
+	depth int
+	bound int
+	move  int
+	score float64
+}
+
+// A fixed-size hash table of searched positions that can be shared by any
+// number of goroutines. Scores are from the perspective of the AI that
+// stored them, so a table should only be shared between searches using the
+// same color and evaluator.
+//
+// A slot is replaced by a search of at least the same depth, or by anything
+// once the slot is left over from the search for an earlier move.
+type TranspositionTable struct {
+	slots      []tableSlot
+	mask       uint64
+	generation uint64
+}
+
+// Makes a transposition table using at most the given number of megabytes
+func NewTranspositionTable(megabytes int) *TranspositionTable {
+	count := uint64(megabytes) << 20 / uint64(24)
+	// Round down to a power of two, so that keys can be masked into indices
+	size := uint64(1)
+	for size*2 <= count {
+		size *= 2
+	}
+	return &TranspositionTable{
+		slots: make([]tableSlot, size),
+		mask:  size - 1,
+	}
+}
+
+// Forgets every stored position
+func (t *TranspositionTable) Clear() {
+	for i := range t.slots {
+		atomic.StoreUint64(&t.slots[i].check, 0)
+		atomic.StoreUint64(&t.slots[i].score, 0)
+		atomic.StoreUint64(&t.slots[i].data, 0)
+	}
+}
+
+// Marks the start of the search for a new move, making older slots
+// available for replacement
+func (t *TranspositionTable) newSearch() {
+	atomic.AddUint64(&t.generation, 1)
+}
+
+// The data word is laid out as depth (8 bits), bound (2 bits),
+// move + 1 (8 bits) and generation (16 bits).
+func packData(depth, bound, move int, generation uint64) uint64 {
+	return uint64(depth&0xFF) |
+		uint64(bound)<<8 |
+		uint64(move+1)<<10 |
+		(generation&0xFFFF)<<18 |
+		1<<34 // Marks the slot as used
+}
+
+func (t *TranspositionTable) probe(key uint64) (entry tableEntry, ok bool) {
+	slot := &t.slots[key&t.mask]
+	check := atomic.LoadUint64(&slot.check)
+	score := atomic.LoadUint64(&slot.score)
+	data := atomic.LoadUint64(&slot.data)
+	if data == 0 || check^score^data != key {
+		return
+	}
+	return tableEntry{
+		depth: int(data & 0xFF),
+		bound: int(data>>8) & 3,
+		move:  int(data>>10)&0xFF - 1,
+		score: math.Float64frombits(score),
+	}, true
+}
+
+func (t *TranspositionTable) store(key uint64, depth, bound, move int,
+	score float64) {
+	slot := &t.slots[key&t.mask]
+	generation := atomic.LoadUint64(&t.generation)
+	// Keep deeper results from this search
+	old := atomic.LoadUint64(&slot.data)
+	if old != 0 &&
+		(old>>18)&0xFFFF == generation&0xFFFF &&
+		int(old&0xFF) > depth {
+		return
+	}
+	data := packData(depth, bound, move, generation)
+	bits := math.Float64bits(score)
+	atomic.StoreUint64(&slot.check, key^bits^data)
+	atomic.StoreUint64(&slot.score, bits)
+	atomic.StoreUint64(&slot.data, data)
+}
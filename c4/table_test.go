@@ -0,0 +1,116 @@
+package c4
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTableProbeAfterStore(t *testing.T) {
+	table := NewTranspositionTable(1)
+	if _, ok := table.probe(12345); ok {
+		t.Fatal("Found a key in an empty table")
+	}
+	table.store(12345, 7, lowerBound, 3, -2.5)
+	entry, ok := table.probe(12345)
+	if !ok || entry != (tableEntry{7, lowerBound, 3, -2.5}) {
+		t.Fatalf("Found %v %v, want %v", entry, ok,
+			tableEntry{7, lowerBound, 3, -2.5})
+	}
+	// A key for the same slot isn't mistaken for it
+	if _, ok := table.probe(12345 + table.mask + 1); ok {
+		t.Error("Found another key in the same slot")
+	}
+	// Positions with no best move keep it as -1
+	table.store(54321, 0, exactBound, -1, WinScore-10)
+	if entry, ok := table.probe(54321); !ok || entry.move != -1 ||
+		entry.score != WinScore-10 {
+		t.Errorf("Found %v %v, want no move", entry, ok)
+	}
+	table.Clear()
+	if _, ok := table.probe(12345); ok {
+		t.Error("Found a key after clearing the table")
+	}
+}
+
+// Shallower results don't replace deeper ones from the same search, but
+// replace anything left over from an earlier search
+func TestTableReplacement(t *testing.T) {
+	table := NewTranspositionTable(1)
+	key := uint64(99)
+	table.store(key, 5, exactBound, 2, 1)
+	table.store(key, 3, exactBound, 4, 2)
+	if entry, _ := table.probe(key); entry.depth != 5 || entry.score != 1 {
+		t.Errorf("A shallower result replaced %v", entry)
+	}
+	table.store(key, 5, upperBound, 1, 3)
+	if entry, _ := table.probe(key); entry.bound != upperBound ||
+		entry.score != 3 {
+		t.Errorf("A result of the same depth didn't replace %v", entry)
+	}
+	table.store(key, 8, exactBound, 0, 4)
+	if entry, _ := table.probe(key); entry.depth != 8 {
+		t.Errorf("A deeper result didn't replace %v", entry)
+	}
+	table.newSearch()
+	table.store(key, 2, exactBound, 6, 5)
+	if entry, _ := table.probe(key); entry.depth != 2 || entry.move != 6 {
+		t.Errorf("A result from an earlier search wasn't replaced: %v",
+			entry)
+	}
+}
+
+// A slot whose words come from different writes is ignored
+func TestTableTornEntry(t *testing.T) {
+	table := NewTranspositionTable(1)
+	key := uint64(1234)
+	table.store(key, 4, exactBound, 2, 1.5)
+	slot := &table.slots[key&table.mask]
+	// Another writer's score arrives without its other words
+	slot.score = math.Float64bits(-7)
+	if entry, ok := table.probe(key); ok {
+		t.Errorf("Found torn entry %v", entry)
+	}
+	table.store(key, 4, exactBound, 2, 1.5)
+	slot.data = packData(9, lowerBound, 5, 0)
+	if entry, ok := table.probe(key); ok {
+		t.Errorf("Found torn entry %v", entry)
+	}
+}
+
+// Wins are scored by the number of pieces played, so a win stored while
+// searching from one position is still right when it's found at another
+// ply from another position
+func TestTableWinScoresMoveBetweenPlies(t *testing.T) {
+	rules := Rules{5, 4, 3}
+	root, err := rules.ParseBoard("...../...../R.B.R/BRB.R R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same position two plies in, searched first
+	later, err := root.AfterMove(Red, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if later, err = later.AfterMove(Black, 4); err != nil {
+		t.Fatal(err)
+	}
+	want := testAI(root, -1).Analyze(root)
+
+	ai := testAI(root, -1)
+	ai.Table = NewTranspositionTable(1)
+	ai.Analyze(later)
+	got := ai.Analyze(root)
+	if got.TableHits == 0 {
+		t.Error("Nothing was found in the table")
+	}
+	if got.Move != want.Move || got.Score != want.Score {
+		t.Errorf("With the table, played %v scoring %v, want %v scoring %v",
+			got.Move, got.Score, want.Move, want.Score)
+	}
+	for i := range want.Scores {
+		if got.Scores[i] != want.Scores[i] {
+			t.Errorf("With the table, %v, want %v", got.Scores[i],
+				want.Scores[i])
+		}
+	}
+}
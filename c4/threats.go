@@ -0,0 +1,85 @@
+package c4
+
+import (
+	"math/bits"
+)
+
+// The ways a line can go
+const (
+	Vertical = iota
+	Horizontal
+	// Up and to the right
+	RisingDiagonal
+	// Down and to the right
+	FallingDiagonal
+)
+
+// An empty location where a player would complete a line by moving. The
+// line can have its gap anywhere, like X X _ X. Lines in the same
+// orientation through the same location make one threat.
+type Threat struct {
+	Player      Piece
+	Col, Row    int
+	Orientation int
+}
+
+// Finds the locations where p would complete a line, for each orientation
+func (this State) threatMasks(p Piece) (masks [4]uint64) {
+	mine := this.pieces[p-1]
+	empty := this.geometry.fullBoard &^ (this.pieces[0] | this.pieces[1])
+	for _, line := range this.geometry.lines {
+		// Exactly one location has to be empty, and the rest p's
+		gap := line.bits & empty
+		if gap != 0 && gap&(gap-1) == 0 && line.bits&mine == line.bits&^gap {
+			masks[line.orientation] |= gap
+		}
+	}
+	return
+}
+
+// Finds every threat p has, in order of their locations and then their
+// orientations
+func Threats(game State, p Piece) []Threat {
+	masks := game.threatMasks(p)
+	var all uint64
+	for _, mask := range masks {
+		all |= mask
+	}
+	var threats []Threat
+	rows := game.geometry.rules.Rows
+	for ; all != 0; all &= all - 1 {
+		i := bits.TrailingZeros64(all)
+		for orientation, mask := range masks {
+			if mask&(1<<uint(i)) != 0 {
+				threats = append(threats,
+					Threat{p, i / rows, i % rows, orientation})
+			}
+		}
+	}
+	return threats
+}
+
+// Counts the orientations in which p moving to (col, row) would complete a
+// line. Occupied locations don't make threats.
+func CountThreats(game State, p Piece, col, row int) int {
+	rules := game.GetRules()
+	if col < 0 || col >= rules.Columns || row < 0 || row >= rules.Rows {
+		return 0
+	}
+	g := game.geometry
+	bit := g.bit(col, row)
+	if (game.pieces[0]|game.pieces[1])&bit != 0 {
+		return 0
+	}
+	// Only the lines through the location matter
+	mine := game.pieces[p-1]
+	var found [4]bool
+	count := 0
+	for _, line := range g.winLines[col*rules.Rows+row] {
+		if !found[line.orientation] && line.bits&mine == line.bits&^bit {
+			found[line.orientation] = true
+			count++
+		}
+	}
+	return count
+}
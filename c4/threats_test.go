@@ -0,0 +1,151 @@
+package c4
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// The steps along each orientation of line
+var directions = [4][2]int{
+	Vertical:        {0, 1},
+	Horizontal:      {1, 0},
+	RisingDiagonal:  {1, 1},
+	FallingDiagonal: {1, -1},
+}
+
+// Whether p moving to an empty location would complete a line in an
+// orientation, found by trying every window of WinCount locations through it
+func bruteForceThreat(game State, p Piece, col, row, orientation int) bool {
+	rules := game.GetRules()
+	if game.GetPiece(col, row) != None {
+		return false
+	}
+	d := directions[orientation]
+	for start := 0; start < rules.WinCount; start++ {
+		c, r := col-start*d[0], row-start*d[1]
+		all := true
+		for i := 0; i < rules.WinCount && all; i++ {
+			x, y := c+i*d[0], r+i*d[1]
+			switch {
+			case x < 0 || x >= rules.Columns || y < 0 || y >= rules.Rows:
+				all = false
+			case x != col || y != row:
+				all = game.GetPiece(x, y) == p
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// Boards with pieces dropped in at random, which no game need reach, so
+// that there are plenty of threats of every kind
+func randomBoards(rules Rules, count int, seed int64) []State {
+	r := rand.New(rand.NewSource(seed))
+	var boards []State
+	for len(boards) < count {
+		rows := make([]string, rules.Rows)
+		heights := make([]int, rules.Columns)
+		for col := range heights {
+			heights[col] = r.Intn(rules.Rows + 1)
+		}
+		for row := 0; row < rules.Rows; row++ {
+			line := make([]byte, rules.Columns)
+			for col := range line {
+				switch {
+				case row >= heights[col]:
+					line[col] = '.'
+				case r.Intn(2) == 0:
+					line[col] = 'R'
+				default:
+					line[col] = 'B'
+				}
+			}
+			rows[rules.Rows-1-row] = string(line)
+		}
+		game, err := rules.ParseBoard(strings.Join(rows, "/") + " R")
+		if err != nil {
+			panic(err)
+		}
+		boards = append(boards, game)
+	}
+	return boards
+}
+
+// Threats, CountThreats and the threat masks agree with trying every window
+// through every location, on every rule set
+func TestThreatsMatchBruteForce(t *testing.T) {
+	for _, rules := range testRules {
+		positions := append(randomPositions(rules, 200, 6),
+			randomBoards(rules, 200, 7)...)
+		for _, game := range positions {
+			for _, p := range []Piece{Red, Black} {
+				masks := game.threatMasks(p)
+				var want []Threat
+				for col := 0; col < rules.Columns; col++ {
+					for row := 0; row < rules.Rows; row++ {
+						count := 0
+						for orientation := range directions {
+							found := bruteForceThreat(game, p, col, row,
+								orientation)
+							bit := masks[orientation]&game.geometry.bit(col,
+								row) != 0
+							if found != bit {
+								t.Fatalf("%v %q: %v threat at %v,%v in "+
+									"orientation %v is %v, want %v", rules,
+									game.BoardString(), p, col, row,
+									orientation, bit, found)
+							}
+							if found {
+								count++
+								want = append(want,
+									Threat{p, col, row, orientation})
+							}
+						}
+						if got := CountThreats(game, p, col, row); got != count {
+							t.Fatalf("%v %q: %v has %v threats at %v,%v, "+
+								"want %v", rules, game.BoardString(), p, got,
+								col, row, count)
+						}
+					}
+				}
+				got := Threats(game, p)
+				if len(got) != len(want) {
+					t.Fatalf("%v %q: %v has threats %v, want %v", rules,
+						game.BoardString(), p, got, want)
+				}
+				for i := range got {
+					if got[i] != want[i] {
+						t.Fatalf("%v %q: %v has threats %v, want %v", rules,
+							game.BoardString(), p, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+// A line with its gap in the middle is a threat, even when it's not on the
+// bottom row
+func TestGappedThreats(t *testing.T) {
+	game, err := ParseBoard(
+		"......./......./......./......./BB.B.../RR.R... R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []Threat{{Red, 2, 0, Horizontal},
+		{Black, 2, 1, Horizontal}} {
+		threats := Threats(game, want.Player)
+		if len(threats) != 1 || threats[0] != want {
+			t.Errorf("%v has threats %v, want %v", want.Player, threats,
+				want)
+		}
+		if n := CountThreats(game, want.Player, want.Col, want.Row); n != 1 {
+			t.Errorf("%v has %v threats at %v,%v, want 1", want.Player, n,
+				want.Col, want.Row)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package c4
+
+import (
+	"math/bits"
+)
+
+// The rules from Victor Allis' thesis, "A Knowledge-based Approach of
+// Connect-Four", that AnalyzeZugzwang uses to stop the other player's lines
+const (
+	// Two empty locations in a column, the upper one in a claim row, with
+	// every empty location below them in claimevens too. The controller
+	// gets the upper one by always answering a move in the lower one in the
+	// same column.
+	RuleClaimeven = iota
+	// Two locations that can be played in now, in different columns. The
+	// controller gets one of them by answering a move in either with the
+	// other.
+	RuleBaseinverse
+	// Two empty locations in a column, the upper one not in a claim row.
+	// The controller gets one of them by answering a move in the lower one
+	// with the upper one.
+	RuleVertical
+)
+
+// The names of the rules, by their constants
+var RuleNames = [...]string{"claimeven", "baseinverse", "vertical"}
+
+// The most rule applications AnalyzeZugzwang tries before giving up
+const maxZugzwangSteps = 100000
+
+// A location on the board
+type Location struct {
+	Col, Row int
+}
+
+// One of the rules applied to two locations
+type Solution struct {
+	Rule      int
+	Locations [2]Location
+	// The number of the other player's lines it stops
+	Lines int
+}
+
+// What AnalyzeZugzwang finds out about a position. Claim rows are the top
+// row and every second row below it, which are the even rows on a board
+// with an even number of rows. The controller is the player who gets the
+// last empty location if the board fills up, and who ends up with every
+// claim row location if it always answers the other player in the same
+// column.
+type ZugzwangAnalysis struct {
+	Controller Piece
+	// When it's the controller's turn, the move it plays before the rules
+	// are applied, or -1
+	Move int
+	// The rules that stop every line the other player could still make,
+	// if there are any
+	Solutions []Solution
+	// The number of lines the other player could still make
+	Lines int
+	// Whether the rules stop all of them, so the controller can't lose
+	Refuted bool
+	// Whether the controller also wins by answering every move in the same
+	// column
+	Win bool
+}
+
+// Works out who controls the zugzwang in a position, and whether the
+// claimeven, baseinverse and vertical rules are enough to stop the other
+// player from ever making a line. If it's the controller's turn, each of
+// its moves is tried, and the best is reported if any of them work. The
+// rules only say the controller can't lose, unless the other player's lines
+// are stopped by claimevens alone and the board the controller ends up with
+// has a line. Positions that need too many tries to decide are reported as
+// not refuted.
+func AnalyzeZugzwang(game State) ZugzwangAnalysis {
+	empty := game.geometry.fullBoard &^ (game.pieces[0] | game.pieces[1])
+	controller := game.turn.Other()
+	if bits.OnesCount64(empty)%2 == 1 {
+		controller = game.turn
+	}
+	if game.turn != controller || game.IsDone() {
+		a := game.analyzeZugzwang(controller)
+		a.Move = -1
+		return a
+	}
+
+	best := ZugzwangAnalysis{Controller: controller, Move: -1,
+		Lines: len(game.openLines(controller))}
+	for _, col := range game.geometry.colOrder {
+		next, err := game.AfterMove(controller, col)
+		if err != nil {
+			continue
+		}
+		a := next.analyzeZugzwang(controller)
+		a.Move = col
+		if a.Win && !best.Win || a.Refuted && !best.Refuted {
+			best = a
+		}
+		if a.Win {
+			break
+		}
+	}
+	return best
+}
+
+// The lines the other player could still make
+func (this State) openLines(controller Piece) []uint64 {
+	var lines []uint64
+	for _, line := range this.geometry.lines {
+		if line.bits&this.pieces[controller-1] == 0 {
+			lines = append(lines, line.bits)
+		}
+	}
+	return lines
+}
+
+// The locations the controller gets by always answering in the same column
+func (g *geometry) claimRows() uint64 {
+	var claim uint64
+	for col := 0; col < g.rules.Columns; col++ {
+		for row := g.rules.Rows - 1; row >= 0; row -= 2 {
+			claim |= g.bit(col, row)
+		}
+	}
+	return claim
+}
+
+// A rule applied to some locations, and the lines it stops
+type solution struct {
+	rule      int
+	locations uint64
+	// For claimevens, the empty locations below, which have to be in
+	// claimevens too
+	below uint64
+	lines []int
+}
+
+// Analyzes a position where it's the other player's turn, or the game is
+// over
+func (this State) analyzeZugzwang(controller Piece) ZugzwangAnalysis {
+	a := ZugzwangAnalysis{Controller: controller}
+	if winner := this.GetWinner(); winner != None {
+		a.Refuted = winner == controller
+		a.Win = a.Refuted
+		return a
+	}
+	g := this.geometry
+	theirs := this.pieces[controller.Other()-1]
+	mine := this.pieces[controller-1]
+	empty := g.fullBoard &^ (theirs | mine)
+	claim := g.claimRows()
+
+	lines := this.openLines(controller)
+	a.Lines = len(lines)
+	if len(lines) == 0 {
+		a.Refuted = true
+		return a
+	}
+
+	// Every way of applying the rules that stops at least one line
+	var solutions []solution
+	add := func(rule int, locations, below, stops uint64) {
+		s := solution{rule: rule, locations: locations, below: below}
+		for i, line := range lines {
+			if line&stops == stops {
+				s.lines = append(s.lines, i)
+			}
+		}
+		if len(s.lines) > 0 {
+			solutions = append(solutions, s)
+		}
+	}
+	var playable []uint64
+	for col := 0; col < g.rules.Columns; col++ {
+		top := int(this.top[col])
+		if top < g.rules.Rows {
+			playable = append(playable, g.bit(col, top))
+		}
+		var below uint64
+		for row := top; row+1 < g.rules.Rows; row++ {
+			lower, upper := g.bit(col, row), g.bit(col, row+1)
+			if claim&upper == 0 {
+				add(RuleVertical, lower|upper, 0, lower|upper)
+			} else if (row-top)%2 == 0 {
+				// The locations below can only be claimed if they pair up
+				// into claimevens from the top of the column
+				add(RuleClaimeven, lower|upper, below, upper)
+			}
+			below |= lower
+		}
+	}
+	for i := range playable {
+		for _, other := range playable[i+1:] {
+			add(RuleBaseinverse, playable[i]|other, 0, playable[i]|other)
+		}
+	}
+
+	// The solutions that could stop each line
+	stoppedBy := make([][]int, len(lines))
+	for i, s := range solutions {
+		for _, line := range s.lines {
+			stoppedBy[line] = append(stoppedBy[line], i)
+		}
+	}
+	z := zugzwangSearch{solutions: solutions, stoppedBy: stoppedBy,
+		stopped: make([]int, len(lines))}
+	if !z.solve(0, 0) {
+		return a
+	}
+	a.Refuted = true
+	for _, i := range z.chosen {
+		s := solutions[i]
+		var locations [2]Location
+		j := 0
+		for l := s.locations; l != 0; l &= l - 1 {
+			k := bits.TrailingZeros64(l)
+			locations[j] = Location{k / g.rules.Rows, k % g.rules.Rows}
+			j++
+		}
+		a.Solutions = append(a.Solutions, Solution{s.rule, locations,
+			len(s.lines)})
+	}
+
+	// If claimevens alone stop everything, the rest of the game is fixed
+	// as long as the controller keeps answering in the same column
+	for col := 0; col < g.rules.Columns; col++ {
+		if (g.rules.Rows-int(this.top[col]))%2 != 0 {
+			return a
+		}
+	}
+	for _, line := range lines {
+		if line&empty&claim == 0 {
+			return a
+		}
+	}
+	final := mine | empty&claim
+	for _, line := range g.lines {
+		if line.bits&final == line.bits {
+			a.Win = true
+			break
+		}
+	}
+	return a
+}
+
+// Whether a solution can be chosen along with the ones that used and
+// claimed some locations
+func (s solution) fits(used, claimed uint64) bool {
+	if s.rule == RuleClaimeven {
+		return (s.locations|s.below)&used&^claimed == 0
+	}
+	return s.locations&used == 0
+}
+
+// Looks for rules that don't share any locations and stop every line
+type zugzwangSearch struct {
+	solutions []solution
+	stoppedBy [][]int
+	// How many of the chosen solutions stop each line
+	stopped []int
+	chosen  []int
+	steps   int
+}
+
+// Chooses solutions for the lines that aren't stopped yet, starting with
+// the line with the fewest choices, without using any of the locations
+// that are already used. Claimevens can share the locations that claimevens
+// have claimed, which are the ones they use and the ones below them.
+func (z *zugzwangSearch) solve(used, claimed uint64) bool {
+	best := -1
+	var choices []int
+	for line, stopped := range z.stopped {
+		if stopped > 0 {
+			continue
+		}
+		var c []int
+		for _, i := range z.stoppedBy[line] {
+			if z.solutions[i].fits(used, claimed) {
+				c = append(c, i)
+			}
+		}
+		if len(c) == 0 {
+			return false
+		}
+		if best < 0 || len(c) < len(choices) {
+			best, choices = line, c
+		}
+	}
+	if best < 0 {
+		return true
+	}
+	for _, i := range choices {
+		z.steps++
+		if z.steps > maxZugzwangSteps {
+			return false
+		}
+		s := z.solutions[i]
+		for _, line := range s.lines {
+			z.stopped[line]++
+		}
+		z.chosen = append(z.chosen, i)
+		newClaimed := claimed
+		if s.rule == RuleClaimeven {
+			newClaimed |= s.locations | s.below
+		}
+		if z.solve(used|s.locations|s.below, newClaimed) {
+			return true
+		}
+		z.chosen = z.chosen[:len(z.chosen)-1]
+		for _, line := range s.lines {
+			z.stopped[line]--
+		}
+	}
+	return false
+}
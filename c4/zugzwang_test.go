@@ -0,0 +1,71 @@
+package c4
+
+import (
+	"testing"
+)
+
+// With a column of alternating pieces in the middle, black at the bottom,
+// every horizontal line in an odd row is blocked, so black can't lose by
+// claiming every other column with claimevens, as Allis showed for boards
+// like this one
+func TestClaimevenDraw(t *testing.T) {
+	game, err := ParseBoard(
+		"...R.../...B.../...R.../...B.../...R.../...B... R")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := AnalyzeZugzwang(game)
+	if a.Controller != Black || !a.Refuted || a.Win {
+		t.Fatalf("Controller %v, refuted %v, win %v, want black to draw",
+			a.Controller, a.Refuted, a.Win)
+	}
+	for _, s := range a.Solutions {
+		if s.Rule != RuleClaimeven {
+			t.Errorf("Used %v at %v", RuleNames[s.Rule], s.Locations)
+		}
+	}
+}
+
+// Claimevens only go on empty locations that pair up into claimevens from
+// the top of their column, so nothing else can use the locations below them
+func TestClaimevenNeedsLocationsBelow(t *testing.T) {
+	// Black could only stop red's lines with claimevens above the empty
+	// second row of column 6
+	game, err := ParseMoves("247634743215431337147451")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := AnalyzeZugzwang(game); a.Refuted {
+		t.Errorf("%q: refuted with %v", game.MoveString(), a.Solutions)
+	}
+
+	for _, game := range randomPositions(StandardRules, 2000, 10) {
+		a := AnalyzeZugzwang(game)
+		if a.Move >= 0 {
+			game.Move(game.GetTurn(), a.Move)
+		}
+		for _, s := range a.Solutions {
+			if s.Rule != RuleClaimeven {
+				continue
+			}
+			col, lower := s.Locations[0].Col, s.Locations[0].Row
+			if (lower-game.GetTop(col))%2 != 0 {
+				t.Fatalf("%q: claimeven at %v with %v empty locations "+
+					"below", game.MoveString(), s.Locations,
+					lower-game.GetTop(col))
+			}
+			for _, other := range a.Solutions {
+				if other.Rule == RuleClaimeven {
+					continue
+				}
+				for _, l := range other.Locations {
+					if l.Col == col && l.Row < lower {
+						t.Fatalf("%q: claimeven at %v above %v at %v",
+							game.MoveString(), s.Locations,
+							RuleNames[other.Rule], other.Locations)
+					}
+				}
+			}
+		}
+	}
+}
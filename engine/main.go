@@ -0,0 +1,557 @@
+package main
+
+// A text protocol for driving the AI from other programs, modelled on the
+// Universal Chess Interface. Commands are read a line at a time from stdin,
+// and replies are written to stdout:
+//
+//	uci                       Replies with the engine's name, its options
+//	                          and uciok
+//	isready                   Replies readyok
+//	setoption name N value V  Sets an option. The evaluator is set by the
+//	                          Coefficients option, by the Weights option
+//	                          as feature=weight for each feature, or by the
+//	                          Network option as the file a neural network
+//	                          was written to by train-mlp.
+//	ucinewgame                Forgets everything from the last game
+//	position startpos [moves 4453 ...]
+//	position board <board> <turn> [moves ...]
+//	                          Sets up the position to search. Moves are in
+//	                          the notation of c4.ParseMoves, and can be
+//	                          given all at once or one at a time. Boards are
+//	                          in the notation of c4.ParseBoard.
+//	go [depth D] [movetime MS] [wtime MS] [btime MS] [winc MS] [binc MS]
+//	   [infinite]             Searches the position, writing an info line for
+//	                          every depth and bestmove at the end
+//	stop                      Stops searching as soon as possible
+//	bench [depth D]           Searches some positions once looking at the
+//	                          whole board for every position and once
+//	                          keeping the evaluation up to date as moves are
+//	                          played, if the evaluator can, and compares them
+//	quit                      Exits
+//
+// At the end of the input, the engine waits for the search to finish before
+// exiting.
+//
+// Info lines look like
+//
+//	info depth 8 score cp 39 nodes 18211 time 23 pv 4 4 5 3
+//
+// where the score is from the point of view of the player to move, in
+// hundredths of an evaluator point, or "score mate N" when the player to move
+// can win in N moves, or will lose in -N moves.
+
+import (
+	"../c4"
+	"../c4/mlp"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDepth = 8
+const defaultHash = 64
+
+type engine struct {
+	// Replies can come from the search as well as the commands
+	out sync.Mutex
+
+	evaluator c4.Evaluator
+	hash      int
+	threads   int
+	table     *c4.TranspositionTable
+	// The player the table's scores are for
+	tableColor c4.Piece
+	game       c4.State
+
+	// The search that's running, if there is one
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// The coefficients found by ga
+var evolvedFactors = c4.EvalFactors{
+	0.2502943943301069,
+	-0.4952316649483701,
+	0.3932539700819625,
+	-0.2742452616759889,
+	0.4746881137884282,
+	0.2091091127191147}
+
+func newEngine() *engine {
+	return &engine{
+		evaluator: evolvedFactors,
+		hash:      defaultHash,
+		threads:   runtime.NumCPU(),
+		table:     c4.NewTranspositionTable(defaultHash),
+		game:      c4.NewState(c4.StandardRules),
+	}
+}
+
+func (e *engine) send(format string, args ...interface{}) {
+	e.out.Lock()
+	defer e.out.Unlock()
+	fmt.Printf(format+"\n", args...)
+}
+
+// Runs a command, returning false when it's time to quit
+func (e *engine) command(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	switch fields[0] {
+	case "uci":
+		e.send("id name cs773c-connect4-minimax")
+		e.send("id author TJSomething")
+		e.send("option name Hash type spin default %v min 1 max 4096",
+			defaultHash)
+		e.send("option name Threads type spin default %v min 1 max 256",
+			runtime.NumCPU())
+		e.send("option name Coefficients type string default %v",
+			formatFactors(evolvedFactors))
+		e.send("option name Weights type string default <empty>")
+		e.send("option name Network type string default <empty>")
+		e.send("uciok")
+	case "isready":
+		e.send("readyok")
+	case "setoption":
+		e.stop()
+		if err := e.setOption(fields[1:]); err != nil {
+			e.send("info string %v", err)
+		}
+	case "ucinewgame":
+		e.stop()
+		e.table.Clear()
+		e.game = c4.NewState(c4.StandardRules)
+	case "position":
+		e.stop()
+		if err := e.setPosition(fields[1:]); err != nil {
+			e.send("info string %v", err)
+		}
+	case "go":
+		e.stop()
+		if err := e.goSearch(fields[1:]); err != nil {
+			e.send("info string %v", err)
+		}
+	case "stop":
+		e.stop()
+	case "bench":
+		e.stop()
+		if err := e.bench(fields[1:]); err != nil {
+			e.send("info string %v", err)
+		}
+	case "quit":
+		e.stop()
+		return false
+	default:
+		e.send("info string Unknown command %v", fields[0])
+	}
+	return true
+}
+
+// Handles "setoption name <name> value <value>"
+func (e *engine) setOption(args []string) error {
+	if len(args) < 4 || args[0] != "name" || args[2] != "value" {
+		return errors.New("Usage: setoption name <name> value <value>")
+	}
+	name, values := args[1], args[3:]
+	switch strings.ToLower(name) {
+	case "hash":
+		mb, err := strconv.Atoi(values[0])
+		if err != nil || mb < 1 {
+			return errors.New(fmt.Sprintf(
+				"Invalid hash size %v", values[0]))
+		}
+		e.hash = mb
+		e.table = c4.NewTranspositionTable(mb)
+	case "threads":
+		threads, err := strconv.Atoi(values[0])
+		if err != nil || threads < 1 {
+			return errors.New(fmt.Sprintf(
+				"Invalid number of threads %v", values[0]))
+		}
+		e.threads = threads
+	case "coefficients":
+		var coeffs [6]float64
+		if len(values) != len(coeffs) {
+			return errors.New(fmt.Sprintf(
+				"There must be %v coefficients", len(coeffs)))
+		}
+		for i, v := range values {
+			var err error
+			if coeffs[i], err = strconv.ParseFloat(v, 64); err != nil {
+				return errors.New(fmt.Sprintf("Invalid coefficient %v", v))
+			}
+		}
+		e.evaluator = c4.EvalFactors{coeffs[0], coeffs[1], coeffs[2],
+			coeffs[3], coeffs[4], coeffs[5]}
+		// Scores from the old evaluator are no good any more
+		e.table.Clear()
+	case "weights":
+		// Weights are given as feature=weight
+		weights := make(map[string]float64)
+		for _, v := range values {
+			i := strings.LastIndex(v, "=")
+			if i < 0 {
+				return errors.New(fmt.Sprintf("Invalid weight %v", v))
+			}
+			w, err := strconv.ParseFloat(v[i+1:], 64)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Invalid weight %v", v))
+			}
+			weights[v[:i]] = w
+		}
+		evaluator, err := c4.NewLinearEvaluator(weights)
+		if err != nil {
+			return err
+		}
+		e.evaluator = evaluator
+		e.table.Clear()
+	case "network":
+		// File names can have spaces
+		file, err := os.Open(strings.Join(values, " "))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		n, err := mlp.Read(file)
+		if err != nil {
+			return err
+		}
+		e.evaluator = n
+		e.table.Clear()
+	default:
+		return errors.New(fmt.Sprintf("Unknown option %v", name))
+	}
+	return nil
+}
+
+// Handles "position startpos|board <board> <turn> [moves ...]"
+func (e *engine) setPosition(args []string) error {
+	var game c4.State
+	var err error
+	switch {
+	case len(args) >= 1 && args[0] == "startpos":
+		game = c4.NewState(c4.StandardRules)
+		args = args[1:]
+	case len(args) >= 3 && args[0] == "board":
+		if game, err = c4.ParseBoard(args[1] + " " + args[2]); err != nil {
+			return err
+		}
+		args = args[3:]
+	default:
+		return errors.New(
+			"Usage: position startpos|board <board> <turn> [moves ...]")
+	}
+	if len(args) > 0 {
+		if args[0] != "moves" {
+			return errors.New(fmt.Sprintf("Expected moves, not %v", args[0]))
+		}
+		for _, moves := range args[1:] {
+			for _, c := range moves {
+				col, err := strconv.ParseInt(string(c), 36, 0)
+				if err != nil || col < 1 {
+					return errors.New(fmt.Sprintf("Invalid move %q", c))
+				}
+				if game.IsDone() {
+					return errors.New("The game is already over")
+				}
+				if err := game.Move(game.GetTurn(), int(col)-1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	e.game = game
+	return nil
+}
+
+// Handles "go", starting a search that runs until it's finished or stopped
+func (e *engine) goSearch(args []string) error {
+	game := e.game
+	// Scores in the table are from the point of view of the player who
+	// searched, so they're no good to the other one
+	if e.tableColor != game.GetTurn() {
+		e.table.Clear()
+		e.tableColor = game.GetTurn()
+	}
+	ai := c4.AlphaBetaAI{
+		Color:    game.GetTurn(),
+		Depth:    defaultDepth,
+		EvalFunc: e.evaluator.Eval,
+		TerminalTest: func(game c4.State) bool {
+			return game.GetWinner() != c4.None
+		},
+		Table:   e.table,
+		Workers: e.threads,
+	}
+	if incremental, ok := e.evaluator.(c4.IncrementalEvaluator); ok {
+		ai.Evaluator = incremental
+	}
+	if game.IsDone() {
+		return errors.New("The game is already over")
+	}
+	if n, ok := e.evaluator.(*mlp.Network); ok && n.Rules != game.GetRules() {
+		return errors.New("The network is for other rules")
+	}
+
+	// Searches limited by time go as deep as the time allows, unless a
+	// depth is given too
+	depthGiven, timed := false, false
+	for i := 0; i < len(args); i++ {
+		if args[i] == "infinite" {
+			timed = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return errors.New(fmt.Sprintf("Missing value for %v", args[i]))
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return errors.New(fmt.Sprintf(
+				"Invalid value %v for %v", args[i+1], args[i]))
+		}
+		ms := time.Duration(n) * time.Millisecond
+		switch args[i] {
+		case "depth":
+			ai.Depth = n
+			depthGiven = true
+		case "movetime":
+			ai.MoveTime = ms
+		case "wtime", "btime":
+			// The first player is white in chess, so they're red here
+			if (args[i] == "wtime") == (game.GetTurn() == c4.Red) {
+				ai.TimeLeft = ms
+			}
+		case "winc", "binc":
+			if (args[i] == "winc") == (game.GetTurn() == c4.Red) {
+				ai.Increment = ms
+			}
+		default:
+			return errors.New(fmt.Sprintf("Unknown go parameter %v", args[i]))
+		}
+		timed = timed || args[i] != "depth"
+		i++
+	}
+	if timed && !depthGiven {
+		ai.Depth = -1
+	}
+
+	ai.Progress = func(result c4.SearchResult) {
+		e.send("info depth %v score %v nodes %v time %v pv %v",
+			result.Depth, formatScore(game, result.Score), result.Nodes,
+			int64(result.Elapsed/time.Millisecond), formatMoves(result.PV))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	e.cancel, e.done = cancel, done
+	go func() {
+		defer close(done)
+		result := ai.AnalyzeContext(ctx, game)
+		e.send("bestmove %v", formatMoves([]int{result.Move}))
+	}()
+	return nil
+}
+
+// The positions bench searches
+var benchPositions = []string{"", "4453", "44444", "3443", "45345362",
+	"4433552", "1234567", "44536251"}
+
+// Handles "bench [depth D]"
+func (e *engine) bench(args []string) error {
+	depth := defaultDepth
+	if len(args) > 0 {
+		var err error
+		if len(args) != 2 || args[0] != "depth" {
+			return errors.New("Usage: bench [depth D]")
+		}
+		if depth, err = strconv.Atoi(args[1]); err != nil || depth < 1 {
+			return errors.New(fmt.Sprintf("Invalid depth %v", args[1]))
+		}
+	}
+	incremental, ok := e.evaluator.(c4.IncrementalEvaluator)
+	if !ok {
+		return errors.New("The evaluator can't be updated incrementally")
+	}
+
+	// Both searches use one thread and no table, so they search exactly the
+	// same positions
+	search := func(game c4.State,
+		evaluator c4.IncrementalEvaluator) c4.SearchResult {
+		ai := c4.AlphaBetaAI{
+			Color:     game.GetTurn(),
+			Depth:     depth,
+			EvalFunc:  e.evaluator.Eval,
+			Evaluator: evaluator,
+			TerminalTest: func(game c4.State) bool {
+				return game.GetWinner() != c4.None
+			},
+			Workers: 1,
+		}
+		return ai.Analyze(game)
+	}
+	var fullNodes, incNodes int64
+	var fullTime, incTime time.Duration
+	for _, moves := range benchPositions {
+		game, err := c4.StandardRules.ParseMoves(moves)
+		if err != nil {
+			return err
+		}
+		full, inc := search(game, nil), search(game, incremental)
+		if full.Move != inc.Move || full.Score != inc.Score ||
+			full.Nodes != inc.Nodes {
+			return errors.New(fmt.Sprintf(
+				"The searches of %q disagree: %v %v %v and %v %v %v", moves,
+				full.Move, full.Score, full.Nodes,
+				inc.Move, inc.Score, inc.Nodes))
+		}
+		e.send("info string position %q nodes %v full %v incremental %v",
+			moves, full.Nodes, int64(full.Elapsed/time.Millisecond),
+			int64(inc.Elapsed/time.Millisecond))
+		fullNodes += full.Nodes
+		incNodes += inc.Nodes
+		fullTime += full.Elapsed
+		incTime += inc.Elapsed
+	}
+	e.send("info string full nodes %v time %v nps %v", fullNodes,
+		int64(fullTime/time.Millisecond), nodesPerSecond(fullNodes, fullTime))
+	e.send("info string incremental nodes %v time %v nps %v", incNodes,
+		int64(incTime/time.Millisecond), nodesPerSecond(incNodes, incTime))
+
+	// Without the rest of the search, the difference is just in scoring
+	// the leaves, including keeping the incremental evaluation up to date
+	// on the way to them
+	var leaves int64
+	var fullEval, incEval time.Duration
+	for _, moves := range benchPositions {
+		game, _ := c4.StandardRules.ParseMoves(moves)
+		start := time.Now()
+		n, fullSum := evalLeaves(game, benchLeafDepth, e.evaluator.Eval, nil)
+		fullEval += time.Since(start)
+		start = time.Now()
+		inc := incremental.NewIncremental(game)
+		_, incSum := evalLeaves(game, benchLeafDepth, inc.Eval, inc)
+		incEval += time.Since(start)
+		if fullSum != incSum {
+			return errors.New(fmt.Sprintf(
+				"The leaves of %q score differently", moves))
+		}
+		leaves += n
+	}
+	e.send("info string leaves %v full %v ns each incremental %v ns each",
+		leaves, fullEval.Nanoseconds()/leaves, incEval.Nanoseconds()/leaves)
+	return nil
+}
+
+// How many moves ahead of each position bench scores every leaf
+const benchLeafDepth = 6
+
+// Scores every position depth moves ahead of game, returning how many there
+// were and the sum of their scores. If inc is given, it's kept up to date
+// with the moves.
+func evalLeaves(game c4.State, depth int, eval func(c4.State, c4.Piece) float64,
+	inc c4.Incremental) (int64, float64) {
+	if depth == 0 || game.GetWinner() != c4.None {
+		return 1, eval(game, c4.Red)
+	}
+	var leaves int64
+	var sum float64
+	for col := 0; col < game.GetRules().Columns; col++ {
+		next, err := game.AfterMove(game.GetTurn(), col)
+		if err != nil {
+			continue
+		}
+		if inc != nil {
+			inc.Moved(next, col)
+		}
+		n, s := evalLeaves(next, depth-1, eval, inc)
+		if inc != nil {
+			inc.Undone(game, col)
+		}
+		leaves += n
+		sum += s
+	}
+	return leaves, sum
+}
+
+func nodesPerSecond(nodes int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(nodes) / elapsed.Seconds())
+}
+
+// Stops the search that's running, if there is one, waiting for its bestmove
+func (e *engine) stop() {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+		e.cancel, e.done = nil, nil
+	}
+}
+
+// Writes a score the way UCI does, from the point of view of the player to
+// move, which is who the AI searched for
+func formatScore(game c4.State, score float64) string {
+	if math.Abs(score) > c4.WinScore-c4.MaxColumns*c4.MaxRows {
+		// Wins are scored by the number of pieces on the board at the end
+		plies := int(c4.WinScore-math.Abs(score)+0.5) - pieces(game)
+		moves := (plies + 1) / 2
+		if score < 0 {
+			moves = -moves
+		}
+		return fmt.Sprintf("mate %v", moves)
+	}
+	return fmt.Sprintf("cp %v", int(math.Floor(score*100+0.5)))
+}
+
+func pieces(game c4.State) int {
+	count := 0
+	for col := 0; col < game.GetRules().Columns; col++ {
+		count += game.GetTop(col)
+	}
+	return count
+}
+
+func formatMoves(moves []int) string {
+	s := make([]string, len(moves))
+	for i, col := range moves {
+		s[i] = strconv.FormatInt(int64(col+1), 36)
+	}
+	return strings.Join(s, " ")
+}
+
+func formatFactors(f c4.EvalFactors) string {
+	return strings.Join([]string{
+		strconv.FormatFloat(f.Win, 'g', -1, 64),
+		strconv.FormatFloat(f.Lose, 'g', -1, 64),
+		strconv.FormatFloat(f.MyOdd, 'g', -1, 64),
+		strconv.FormatFloat(f.TheirOdd, 'g', -1, 64),
+		strconv.FormatFloat(f.MyEven, 'g', -1, 64),
+		strconv.FormatFloat(f.TheirEven, 'g', -1, 64)}, " ")
+}
+
+func main() {
+	// Use all processors
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	e := newEngine()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if !e.command(scanner.Text()) {
+			return
+		}
+	}
+	// At the end of the input, let the last search finish
+	if e.done != nil {
+		<-e.done
+	}
+}
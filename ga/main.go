@@ -2,6 +2,8 @@ package main
 
 import (
 	"../c4"
+	"../c4/record"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -18,6 +20,59 @@ const PopSize = 100
 const BattleCount = 5
 const mutationStdDev = 0.03
 
+// The features whose weights are evolved, which can be any of
+// c4.FeatureNames. These are the ones EvalFactors has, in its order.
+var featureNames = []string{
+	"win",
+	"lose",
+	"my-odd-threats",
+	"their-odd-threats",
+	"my-even-threats",
+	"their-even-threats",
+}
+
+// The weight of each feature
+type genome map[string]float64
+
+// Reads a population, which can also be in the old format of six
+// coefficients in the order of featureNames
+func readPopulation(name string) ([]genome, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var pop []genome
+	if err := json.NewDecoder(file).Decode(&pop); err == nil {
+		return pop, nil
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	var old [][6]float64
+	if err := json.NewDecoder(file).Decode(&old); err != nil {
+		return nil, err
+	}
+	pop = nil
+	for _, coeffs := range old {
+		g := make(genome)
+		for i, c := range coeffs {
+			g[featureNames[i]] = c
+		}
+		pop = append(pop, g)
+	}
+	return pop, nil
+}
+
+// Makes an evaluator for a genome
+func newEvaluator(g genome) *c4.LinearEvaluator {
+	e, err := c4.NewLinearEvaluator(g)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return e
+}
+
 func main() {
 	// Use all processors
 	runtime.GOMAXPROCS(runtime.NumCPU())
@@ -25,23 +80,18 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 
 	// Initialize population
-	pop := make([][6]float64, 0, PopSize)
-	var newPop [][6]float64
+	pop := make([]genome, 0, PopSize)
+	var newPop []genome
 	var wins [PopSize]int
 	var fitness [PopSize + 1]float64
 	var generation int
-	var tempGenome [6]float64
+	var tempGenome genome
 	// If there's an argument for it, read the population
-	if len(os.Args) == 2 {
-		file, err := os.Open(os.Args[1])
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Println(err)
-			}
-		} else {
-			decoder := json.NewDecoder(file)
-			if err := decoder.Decode(&pop); err != nil {
-				log.Println(err)
+	if len(os.Args) >= 2 {
+		var err error
+		if pop, err = readPopulation(os.Args[1]); err != nil {
+			log.Println(err)
+			if !os.IsNotExist(err) {
 				log.Println("Writing new file")
 			}
 		}
@@ -49,40 +99,46 @@ func main() {
 	// Otherwise, generate one randomly. This also fills up empty space
 	// in undersized populations that have been loaded
 	for i := len(pop); i < PopSize; i++ {
-		for j := 0; j < 6; j++ {
-			tempGenome[j] = 2*rand.Float64() - 1
+		pop = append(pop, make(genome))
+	}
+	// Features that are new to a loaded population start out random too
+	for _, g := range pop {
+		for _, name := range featureNames {
+			if _, ok := g[name]; !ok {
+				g[name] = 2*rand.Float64() - 1
+			}
+		}
+	}
+	// If there's another argument, keep a record of every game in it
+	var games *record.Writer
+	if len(os.Args) == 3 {
+		file, err := os.OpenFile(os.Args[2],
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
 		}
-		pop = append(pop, tempGenome)
+		defer file.Close()
+		games = record.NewWriter(file)
 	}
 	var genomeOrder []int
 	// Function/closures for each game
 	isDone := func(game c4.State) bool {
 		return game.IsDone()
 	}
-	displayNoBoard := func(game c4.State) {}
-	showError := func(err error) {
-		fmt.Println(err)
-	}
-	winnerChan := make(chan c4.Piece, 1)
-	var winner c4.Piece
-	notifyWinner := func(winner c4.Piece) {
-		if winner == c4.Red {
-			fmt.Println("c4.Red wins!")
-		} else if winner == c4.Black {
-			fmt.Println("c4.Black wins!")
-		} else {
-			fmt.Println("It's a draw.")
+	showError := func(e c4.Event) {
+		if e.Kind == c4.IllegalMove {
+			fmt.Println(e.Err)
 		}
-		winnerChan <- winner
 	}
+	var winner c4.Piece
 	// Fitness temps
 	var acc float64
 	var tempFitness float64
 	var bestFitness float64
-	var bestGenome [6]float64
+	var bestGenome genome
 	// Temps
 	var g1, g2 int
-	var f1, f2 c4.EvalFactors
+	var f1, f2 *c4.LinearEvaluator
 	var randNum float64
 
 	for {
@@ -93,42 +149,60 @@ func main() {
 			genomeOrder = rand.Perm(PopSize)
 			for g1 = 0; g1 < PopSize; g1++ {
 				g2 = genomeOrder[g1]
-				f1 = c4.EvalFactors{pop[g1][0], pop[g1][1], pop[g1][2],
-					pop[g1][3], pop[g1][4], pop[g1][5]}
-				f2 = c4.EvalFactors{pop[g2][0], pop[g2][1], pop[g2][2],
-					pop[g2][3], pop[g2][4], pop[g2][5]}
+				f1 = newEvaluator(pop[g1])
+				f2 = newEvaluator(pop[g2])
 				fmt.Printf(
 					"\nGeneration %v, round %v/%v, genome %v/%v:\n\t"+
 						"%v (%v/%v)\n\tvs\n\t%v (%v/%v)\n",
 					generation, battle+1, BattleCount, g1+1, PopSize,
-					f1, wins[g1], battle*2,
-					f2, wins[g2], battle*2)
+					pop[g1], wins[g1], battle*2,
+					pop[g2], wins[g2], battle*2)
 				// Run a game with the competitors
-				c4.RunGame(
-					c4.AlphaBetaAI{
-						c4.Red,
-						8,
-						func(game c4.State, p c4.Piece) float64 {
-							return f1.Eval(game, p)
-						},
-						isDone,
+				rec := record.New(c4.StandardRules,
+					record.Player{
+						Name:    fmt.Sprintf("Genome %v", g1+1),
+						Depth:   8,
+						Weights: pop[g1]},
+					record.Player{
+						Name:    fmt.Sprintf("Genome %v", g2+1),
+						Depth:   8,
+						Weights: pop[g2]})
+				rec.Event = fmt.Sprintf("ga generation %v, round %v",
+					generation, battle+1)
+				match := &c4.Match{
+					Rules: c4.StandardRules,
+					// A genome that can't make a legal move loses
+					Policy: c4.IllegalMovePolicy{Retries: 0, Forfeit: true},
+					Red: c4.AlphaBetaAI{
+						Color:        c4.Red,
+						Depth:        8,
+						EvalFunc:     f1.Eval,
+						TerminalTest: isDone,
 					},
-					c4.AlphaBetaAI{
-						c4.Black,
-						8,
-						func(game c4.State, p c4.Piece) float64 {
-							return f2.Eval(game, p)
-						},
-						isDone,
+					Black: c4.AlphaBetaAI{
+						Color:        c4.Black,
+						Depth:        8,
+						EvalFunc:     f2.Eval,
+						TerminalTest: isDone,
 					},
-					displayNoBoard,
-					showError,
-					notifyWinner)
+				}
+				match.Subscribe(showError)
+				match.Record(rec)
+				result, _ := match.Play(context.Background())
+				if games != nil {
+					if err := games.Write(rec); err != nil {
+						log.Println(err)
+					}
+				}
 				// Update win counts
-				if winner = <-winnerChan; winner == c4.Red {
+				if winner = result.Winner; winner == c4.Red {
+					fmt.Println("c4.Red wins!")
 					wins[g1]++
 				} else if winner == c4.Black {
+					fmt.Println("c4.Black wins!")
 					wins[g2]++
+				} else {
+					fmt.Println("It's a draw.")
 				}
 			}
 		}
@@ -151,7 +225,7 @@ func main() {
 		// Add a top to the last range
 		fitness[PopSize] = acc
 
-		newPop = make([][6]float64, 0, PopSize)
+		newPop = make([]genome, 0, PopSize)
 		for i := 0; i < PopSize; i++ {
 			// SELECTION
 			// Find two random genomes
@@ -173,18 +247,19 @@ func main() {
 			}
 
 			// CROSSOVER AND MUTATION
-			for j := 0; j < 6; j++ {
+			tempGenome = make(genome, len(featureNames))
+			for _, name := range featureNames {
 				// CROSSOVER
 				// We're just going to pick random genes.
 				// I don't think gene locality is a thing here anyway
 				if rand.Intn(2) == 0 {
-					tempGenome[j] = pop[g1][j]
+					tempGenome[name] = pop[g1][name]
 				} else {
-					tempGenome[j] = pop[g2][j]
+					tempGenome[name] = pop[g2][name]
 				}
 
 				// MUTATION
-				tempGenome[j] += rand.NormFloat64() * mutationStdDev
+				tempGenome[name] += rand.NormFloat64() * mutationStdDev
 			}
 
 			newPop = append(newPop, tempGenome)
@@ -192,7 +267,7 @@ func main() {
 		pop = newPop[0:PopSize]
 
 		// Write the latest generation to a file
-		if len(os.Args) == 2 {
+		if len(os.Args) >= 2 {
 			if file, err := os.Create(os.Args[1]); err == nil {
 				enc := json.NewEncoder(file)
 				enc.Encode(&pop)
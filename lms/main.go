@@ -2,6 +2,7 @@ package main
 
 import (
 	"../c4"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,20 +20,106 @@ const mu = 0.00001
 
 type board [c4.MaxColumns][c4.MaxRows]c4.Piece
 
+// The features the evaluators learn weights for, which can be any of
+// c4.FeatureNames
+var features = func() *c4.LinearEvaluator {
+	e, err := c4.NewLinearEvaluator(map[string]float64{
+		"win":                0,
+		"lose":               0,
+		"my-odd-threats":     0,
+		"their-odd-threats":  0,
+		"my-even-threats":    0,
+		"their-even-threats": 0,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return e
+}()
+
+// The features in the order of the six coefficients that evaluators were
+// stored with before the features had names
+var oldFeatureNames = []string{
+	"win",
+	"lose",
+	"my-even-threats",
+	"their-even-threats",
+	"my-odd-threats",
+	"their-odd-threats",
+}
+
+// Names coefficients in the order of oldFeatureNames
+func oldWeights(coeffs [6]float64) map[string]float64 {
+	weights := make(map[string]float64)
+	for i, c := range coeffs {
+		weights[oldFeatureNames[i]] = c
+	}
+	return weights
+}
+
+// The weights found by ga, which the evaluators are kept close to. They
+// were found for lms' own order of the features, not c4.EvalFactors'.
+var evolvedWeights = oldWeights([6]float64{
+	0.2502943943301069,
+	-0.4952316649483701,
+	0.3932539700819625,
+	-0.2742452616759889,
+	0.4746881137884282,
+	0.2091091127191147})
+
 // An evaluator that keeps track of all evaluated game states and learns from
-// them
+// them. Weights is changed by goroutines started from Eval, so it can only
+// be read through weights.
 type lmsEvaluator struct {
-	Coeffs      [6]float64
+	Weights     map[string]float64
 	coeffsMutex sync.RWMutex
-	count       int
+	count       int64
+}
+
+// How an evaluator is stored. Old files have Coeffs in the order of
+// oldFeatureNames instead of Weights.
+type storedEvaluator struct {
+	Weights map[string]float64
+	Coeffs  *[6]float64 `json:",omitempty"`
 }
 
 // Makes a new evaluator
-func newEvaluator(coeffs [6]float64) lmsEvaluator {
-	var result lmsEvaluator
-	result.Coeffs = [6]float64{coeffs[0], coeffs[1], coeffs[2],
-		coeffs[3], coeffs[4], coeffs[5]}
-	return result
+func newEvaluator(weights map[string]float64) *lmsEvaluator {
+	return &lmsEvaluator{Weights: copyWeights(weights)}
+}
+
+func copyWeights(weights map[string]float64) map[string]float64 {
+	c := make(map[string]float64, len(weights))
+	for name, w := range weights {
+		c[name] = w
+	}
+	return c
+}
+
+// A copy of the weights as they are now
+func (me *lmsEvaluator) weights() map[string]float64 {
+	me.coeffsMutex.RLock()
+	defer me.coeffsMutex.RUnlock()
+	return copyWeights(me.Weights)
+}
+
+// Reads the evaluators and the iteration number written by main, which can
+// also be in the old format
+func readEvaluators(decoder *json.Decoder) ([]*lmsEvaluator, int, error) {
+	var stored []storedEvaluator
+	if err := decoder.Decode(&stored); err != nil {
+		return nil, 0, err
+	}
+	var evaluators []*lmsEvaluator
+	for _, e := range stored {
+		weights := e.Weights
+		if weights == nil && e.Coeffs != nil {
+			weights = oldWeights(*e.Coeffs)
+		}
+		evaluators = append(evaluators, newEvaluator(weights))
+	}
+	var iteration int
+	return evaluators, iteration, decoder.Decode(&iteration)
 }
 
 // Evaluates the game state
@@ -39,9 +127,7 @@ func (me *lmsEvaluator) Eval(game c4.State, p c4.Piece) float64 {
 	var bestScore, knownScore float64
 
 	// Copy out the coefficients to reduce lock contention
-	me.coeffsMutex.RLock()
-	myCoeffs := me.Coeffs
-	me.coeffsMutex.RUnlock()
+	myCoeffs := me.weights()
 
 	// Estimate the game state's utility
 	approxScore, currentFeatures := BetterEval(myCoeffs, game, p)
@@ -54,7 +140,7 @@ func (me *lmsEvaluator) Eval(game c4.State, p c4.Piece) float64 {
 		bestScore = math.Inf(+1)
 	}
 
-	for col := 0; col < c4.MaxColumns; col++ {
+	for col := 0; col < game.GetRules().Columns; col++ {
 		if nextBoard, err := game.AfterMove(game.GetTurn(),
 			col); err == nil {
 			nextScore, _ := BetterEval(
@@ -74,31 +160,25 @@ func (me *lmsEvaluator) Eval(game c4.State, p c4.Piece) float64 {
 		}
 	}
 	// Use the evolved weights as a reference to prevent divergence
-	knownScore, _ = BetterEval([6]float64{
-		0.2502943943301069,
-		-0.4952316649483701,
-		0.3932539700819625,
-		-0.2742452616759889,
-		0.4746881137884282,
-		0.2091091127191147}, game, p)
+	knownScore, _ = BetterEval(evolvedWeights, game, p)
 
 	// Change the coefficients according to the error
-	me.count++
-	if me.count%100000 == 0 {
-		fmt.Println(me.count)
-		fmt.Println(me.Coeffs)
+	// Searches call this from several goroutines at once
+	if count := atomic.AddInt64(&me.count, 1); count%100000 == 0 {
+		fmt.Println(count)
+		fmt.Println(myCoeffs)
 	}
 	// if !math.IsInf(bestScore, 0) {
-	// 	for j := 0; j < 6; j++ {
-	// 		me.Coeffs[j] +=
+	// 	for j, name := range features.Names() {
+	// 		me.Weights[name] +=
 	// 			mu * (bestScore - approxScore) * currentFeatures[j]
 	// 	}
 	// }
 	go func() {
 		if !math.IsInf(bestScore, 0) {
 			me.coeffsMutex.Lock()
-			for j := 0; j < 6; j++ {
-				me.Coeffs[j] +=
+			for j, name := range features.Names() {
+				me.Weights[name] +=
 					mu * (knownScore - approxScore) * currentFeatures[j]
 			}
 			me.coeffsMutex.Unlock()
@@ -108,24 +188,16 @@ func (me *lmsEvaluator) Eval(game c4.State, p c4.Piece) float64 {
 	return approxScore
 }
 
-func BetterEval(coeffs [6]float64, game c4.State, p c4.Piece) (result float64,
-	features [6]float64) {
-	// Winning factor
-	var win, lose float64
-	var myOddThreats, theirOddThreats float64
-	// Odd threats
-	for row := 0; row < c4.MaxRows; row += 2 {
-		for col := 0; col < c4.MaxColumns; col++ {
-			myOddThreats += float64(c4.CountThreats(game, p, col, row))
-			theirOddThreats += float64(c4.CountThreats(game, p.Other(), col, row))
-		}
-	}
-	// Even threats
-	var myEvenThreats, theirEvenThreats float64
-	for row := 1; row < c4.MaxRows; row += 2 {
-		for col := 0; col < c4.MaxColumns; col++ {
-			myEvenThreats += float64(c4.CountThreats(game, p, col, row))
-			theirEvenThreats += float64(c4.CountThreats(game, p.Other(), col, row))
+// Scores a position with some weights, along with the value of each
+// feature in the order of features.Names()
+func BetterEval(weights map[string]float64, game c4.State, p c4.Piece) (
+	result float64, values []float64) {
+	values = features.Values(game, p)
+	// Won and lost games are scored outright, so the win and lose features
+	// are always left at 0, as they were before the features had names
+	for i, name := range features.Names() {
+		if name == "win" || name == "lose" {
+			values[i] = 0
 		}
 	}
 	winner := game.GetWinner()
@@ -136,17 +208,10 @@ func BetterEval(coeffs [6]float64, game c4.State, p c4.Piece) (result float64,
 	} else if game.IsDone() && winner == c4.None {
 		result = 0
 	} else {
-		result = coeffs[0]*win +
-			coeffs[1]*lose +
-			coeffs[2]*myEvenThreats +
-			coeffs[3]*theirEvenThreats +
-			coeffs[4]*myOddThreats +
-			coeffs[5]*theirOddThreats
+		for i, name := range features.Names() {
+			result += weights[name] * values[i]
+		}
 	}
-
-	features = [6]float64{win, lose, myEvenThreats, theirEvenThreats,
-		myOddThreats, theirOddThreats}
-
 	return
 }
 
@@ -157,12 +222,12 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 
 	// Initialize variables
-	evalFuncs := make([]lmsEvaluator, 0, PopSize)
+	evalFuncs := make([]*lmsEvaluator, 0, PopSize)
 	var wins [PopSize]int
 	var iteration int
-	var tempCoeffs [6]float64
+	var tempCoeffs map[string]float64
 	// We need these to find the best player
-	var bestCoeffs [6]float64
+	var bestCoeffs map[string]float64
 	// Temps
 	var g1, g2 int
 
@@ -174,63 +239,74 @@ func main() {
 				log.Println(err)
 			}
 		} else {
-			decoder := json.NewDecoder(file)
-			if err := decoder.Decode(&evalFuncs); err != nil {
+			loaded, i, err := readEvaluators(json.NewDecoder(file))
+			if loaded == nil {
 				log.Println(err)
 				log.Println("Writing new file")
-			} else if err := decoder.Decode(&iteration); err != nil {
+			} else if err != nil {
 				// We also want the the iteration number loaded
 				log.Println(err)
 				log.Println("Iteration number missing")
 			}
+			evalFuncs = append(evalFuncs, loaded...)
+			iteration = i
+			file.Close()
 		}
 	}
 
 	// Otherwise, generate them randomly. This also fills up empty space
 	// if not enough load
 	for i := len(evalFuncs); i < PopSize; i++ {
-		for j := 0; j < 6; j++ {
-			tempCoeffs[j] = 2*rand.Float64() - 1
+		tempCoeffs = make(map[string]float64)
+		for _, name := range features.Names() {
+			tempCoeffs[name] = 2*rand.Float64() - 1
 		}
 		evalFuncs = append(evalFuncs, newEvaluator(tempCoeffs))
 	}
+	// Features that are new to loaded evaluators start out random too
+	for i := range evalFuncs {
+		if evalFuncs[i].Weights == nil {
+			evalFuncs[i].Weights = make(map[string]float64)
+		}
+		for _, name := range features.Names() {
+			if _, ok := evalFuncs[i].Weights[name]; !ok {
+				evalFuncs[i].Weights[name] = 2*rand.Float64() - 1
+			}
+		}
+	}
 
 	// Function/closures for each game
 	isDone := func(game c4.State) bool {
 		return game.IsDone()
 	}
-	displayNoBoard := func(game c4.State) {}
-	showError := func(err error) {
-		fmt.Println(err)
-	}
-	winnerChan := make(chan c4.Piece, 1)
-
-	notifyWinner := func(winner c4.Piece) {
-		if winner == c4.Red {
+	// Plays a game, returning the winner
+	play := func(red, black c4.Player) c4.Piece {
+		match := c4.NewMatch(red, black)
+		match.Subscribe(func(e c4.Event) {
+			if e.Kind == c4.IllegalMove {
+				fmt.Println(e.Err)
+			}
+		})
+		result, _ := match.Play(context.Background())
+		if result.Winner == c4.Red {
 			fmt.Println("Red wins!")
-		} else if winner == c4.Black {
+		} else if result.Winner == c4.Black {
 			fmt.Println("Black wins!")
 		} else {
 			fmt.Println("It's a draw.")
 		}
-		winnerChan <- winner
+		return result.Winner
 	}
 
 	// Coefficients to keep the others honest
 	evolvedRed := c4.AlphaBetaAI{
-		c4.Red,
-		8,
-		func(game c4.State, p c4.Piece) float64 {
-			result, _ := BetterEval([6]float64{
-				0.2502943943301069,
-				-0.4952316649483701,
-				0.3932539700819625,
-				-0.2742452616759889,
-				0.4746881137884282,
-				0.2091091127191147}, game, p)
+		Color: c4.Red,
+		Depth: 8,
+		EvalFunc: func(game c4.State, p c4.Piece) float64 {
+			result, _ := BetterEval(evolvedWeights, game, p)
 			return result
 		},
-		func(game c4.State) bool {
+		TerminalTest: func(game c4.State) bool {
 			return game.GetWinner() != c4.None
 		},
 	}
@@ -257,31 +333,29 @@ func main() {
 					"\nIteration %v, coeffs %v/%v vs coeffs %v/%v:\n\t"+
 						"%v (%v wins)\n\tvs\n\t%v (%v wins)\n",
 					iteration, g1+1, PopSize, g2+1, PopSize,
-					evalFuncs[g1].Coeffs, wins[g1],
-					evalFuncs[g2].Coeffs, wins[g2])
+					evalFuncs[g1].weights(), wins[g1],
+					evalFuncs[g2].weights(), wins[g2])
 				// Run a game with the competitors
-				c4.RunGame(
+				winner := play(
 					c4.AlphaBetaAI{
-						c4.Red,
-						8,
-						func(game c4.State, p c4.Piece) float64 {
+						Color: c4.Red,
+						Depth: 8,
+						EvalFunc: func(game c4.State, p c4.Piece) float64 {
 							return evalFuncs[g1].Eval(game, p)
 						},
-						isDone,
+						TerminalTest: isDone,
 					},
 					c4.AlphaBetaAI{
-						c4.Black,
-						8,
-						func(game c4.State, p c4.Piece) float64 {
+						Color: c4.Black,
+						Depth: 8,
+						EvalFunc: func(game c4.State, p c4.Piece) float64 {
 							return evalFuncs[g2].Eval(game, p)
 						},
-						isDone,
+						TerminalTest: isDone,
 					},
-					displayNoBoard,
-					showError,
-					notifyWinner)
+				)
 				// Update win counts
-				if winner := <-winnerChan; winner == c4.Red {
+				if winner == c4.Red {
 					wins[g1]++
 				} else if winner == c4.Black {
 					wins[g2]++
@@ -289,49 +363,52 @@ func main() {
 			}
 			// Keep them honest by playing them against a proven
 			// set of coefficents
-			c4.RunGame(
+			winner := play(
 				evolvedRed,
 				c4.AlphaBetaAI{
-					c4.Black,
-					8,
-					func(game c4.State, p c4.Piece) float64 {
+					Color: c4.Black,
+					Depth: 8,
+					EvalFunc: func(game c4.State, p c4.Piece) float64 {
 						return evalFuncs[g1].Eval(game, p)
 					},
-					isDone,
+					TerminalTest: isDone,
 				},
-				displayNoBoard,
-				showError,
-				notifyWinner)
-			if winner := <-winnerChan; winner == c4.Black {
+			)
+			if winner == c4.Black {
 				fmt.Printf("\nCoeffs %v beat the champion as black!", g1+1)
 				wins[g1]++
 			}
-			c4.RunGame(
+			winner = play(
 				c4.AlphaBetaAI{
-					c4.Red,
-					8,
-					func(game c4.State, p c4.Piece) float64 {
+					Color: c4.Red,
+					Depth: 8,
+					EvalFunc: func(game c4.State, p c4.Piece) float64 {
 						return evalFuncs[g1].Eval(game, p)
 					},
-					isDone,
+					TerminalTest: isDone,
 				},
 				evolvedBlack,
-				displayNoBoard,
-				showError,
-				notifyWinner)
-			if winner := <-winnerChan; winner == c4.Red {
+			)
+			if winner == c4.Red {
 				fmt.Printf("\nCoeffs %v beat the champion as red!", g1+1)
 				wins[g1]++
 			}
 		}
 
+		// Learning carries on in the background, so take copies of where
+		// the weights are now
+		stored := make([]storedEvaluator, PopSize)
+		for i := range stored {
+			stored[i].Weights = evalFuncs[i].weights()
+		}
+
 		// Find the new best evaluator and run learning
 		mostWins := -1
 		for i := 0; i < PopSize; i++ {
 			// Keep the best coefficients of the iteration
 			if mostWins < wins[i] {
 				mostWins = wins[i]
-				bestCoeffs = evalFuncs[i].Coeffs
+				bestCoeffs = stored[i].Weights
 			}
 		}
 
@@ -339,7 +416,7 @@ func main() {
 		if len(os.Args) == 2 {
 			if file, err := os.Create(os.Args[1]); err == nil {
 				enc := json.NewEncoder(file)
-				enc.Encode(&evalFuncs)
+				enc.Encode(&stored)
 				enc.Encode(&iteration)
 				enc.Encode(&bestCoeffs)
 				enc.Encode(&mostWins)
@@ -0,0 +1,169 @@
+package main
+
+// Builds an opening book by scoring every move in every position the book
+// reaches, starting from the empty board. The moves within -margin of the
+// best score are kept, weighted by how close they are to it, and the
+// positions after them are added in turn, up to -plies moves into the game.
+
+import (
+	"../c4"
+	"../c4/book"
+	"../c4/solver"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+)
+
+// The most a move can be weighted, which the best moves are
+const maxWeight = 10
+
+// The coefficients found by ga
+var evolvedFactors = c4.EvalFactors{
+	0.2502943943301069,
+	-0.4952316649483701,
+	0.3932539700819625,
+	-0.2742452616759889,
+	0.4746881137884282,
+	0.2091091127191147}
+
+// Scores every legal move in a position from the point of view of the
+// player to move. Illegal moves score -Inf.
+type scorer func(game c4.State) [c4.MaxColumns]float64
+
+// Scores moves with searches of the given depth
+func searchScorer(depth int) scorer {
+	// Tables can only be shared between searches for the same color
+	tables := [2]*c4.TranspositionTable{
+		c4.NewTranspositionTable(64), c4.NewTranspositionTable(64)}
+	return func(game c4.State) (scores [c4.MaxColumns]float64) {
+		for col := range scores {
+			scores[col] = math.Inf(-1)
+		}
+		ai := c4.AlphaBetaAI{
+			Color: game.GetTurn(),
+			Depth: depth,
+			EvalFunc: func(game c4.State, p c4.Piece) float64 {
+				return evolvedFactors.Eval(game, p)
+			},
+			TerminalTest: func(game c4.State) bool {
+				return game.GetWinner() != c4.None
+			},
+			Table: tables[game.GetTurn()-1],
+		}
+		for _, s := range ai.Analyze(game).Scores {
+			scores[s.Col] = s.Score
+		}
+		return
+	}
+}
+
+// Scores moves by solving the positions after them
+func solverScorer() scorer {
+	s := solver.New()
+	return func(game c4.State) (scores [c4.MaxColumns]float64) {
+		for col := range scores {
+			scores[col] = math.Inf(-1)
+		}
+		for col, score := range s.ScoreMoves(game) {
+			if score != math.MinInt32 {
+				scores[col] = float64(score)
+			}
+		}
+		return
+	}
+}
+
+// Picks the moves to keep from their scores
+func bookMoves(scores [c4.MaxColumns]float64, margin float64) []book.Move {
+	best := math.Inf(-1)
+	for _, score := range scores {
+		best = math.Max(best, score)
+	}
+	var moves []book.Move
+	for col, score := range scores {
+		if math.IsInf(score, -1) || best-score > margin {
+			continue
+		}
+		weight := maxWeight
+		if margin > 0 {
+			weight = 1 + int(math.Floor(
+				(maxWeight-1)*(margin-(best-score))/margin+0.5))
+		}
+		moves = append(moves, book.Move{Column: col, Weight: weight})
+	}
+	return moves
+}
+
+func main() {
+	// Use all processors
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	var rules c4.Rules
+	flag.IntVar(&rules.Columns, "columns", c4.StandardRules.Columns,
+		"the number of columns on the board")
+	flag.IntVar(&rules.Rows, "rows", c4.StandardRules.Rows,
+		"the number of rows on the board")
+	flag.IntVar(&rules.WinCount, "win", c4.StandardRules.WinCount,
+		"the length of the line needed to win")
+	plies := flag.Int("plies", 6, "how many moves into the game the book goes")
+	depth := flag.Int("depth", 10, "how deep to search each move")
+	margin := flag.Float64("margin", 0,
+		"how much worse than the best move a move can score and be kept")
+	useSolver := flag.Bool("solver", false,
+		"score moves by solving the game, which is slow near the start and "+
+			"only works with the standard rules")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [options] <book file>\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := rules.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if *useSolver && rules != c4.StandardRules {
+		fmt.Println("The solver only works with the standard rules")
+		os.Exit(2)
+	}
+
+	score := searchScorer(*depth)
+	if *useSolver {
+		score = solverScorer()
+	}
+	b := book.New(rules)
+	var explore func(game c4.State)
+	explore = func(game c4.State) {
+		if len(game.Moves()) >= *plies || game.IsDone() ||
+			b.Lookup(game) != nil {
+			return
+		}
+		moves := bookMoves(score(game), *margin)
+		if err := b.Add(game, moves); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v positions, %q: %v\n", b.Len(), game.MoveString(),
+			moves)
+		for _, m := range moves {
+			next, _ := game.AfterMove(game.GetTurn(), m.Column)
+			explore(next)
+		}
+	}
+	explore(c4.NewState(rules))
+
+	file, err := os.Create(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err := b.Write(file); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,38 +2,68 @@ package main
 
 import (
 	"../c4"
+	"context"
+	"flag"
 	"fmt"
 	"github.com/0xe2-0x9a-0x9b/Go-SDL/sdl"
 	"github.com/0xe2-0x9a-0x9b/Go-SDL/ttf"
+	"os"
 	"runtime"
 	"time"
 )
 
 const BOARD_COLOR = 0xFF4050E0
-const SCREEN_WIDTH = 640
-const SCREEN_HEIGHT = 480
+
+// The size of a location on the board
+const PIECE_WIDTH = 640 / 7
+const PIECE_HEIGHT = 480 / 6
+
+// The size of the window, which fits the board
+var SCREEN_WIDTH, SCREEN_HEIGHT int
 
 type SDLHuman struct {
-	Ready chan<- int
-	Move  <-chan int
+	Move <-chan int
 }
 
 func (ui SDLHuman) NextMove(game c4.State) int {
-	// Tell UI that we're ready for a move
-	ui.Ready <- 1
-	// Wait for a move
-	return <-ui.Move
+	return ui.NextMoveContext(context.Background(), game)
 }
 
-func NewUpdater(gameUI chan<- c4.State) func(c4.State) {
-	return func(game c4.State) {
-		gameUI <- game
+// Waits for a move, giving up if the game is abandoned by returning
+// MaxColumns, which is never a column
+func (ui SDLHuman) NextMoveContext(ctx context.Context, game c4.State) int {
+	// The UI knows we're ready from the turn starting
+	select {
+	case col := <-ui.Move:
+		return col
+	case <-ctx.Done():
+		return c4.MaxColumns
 	}
 }
 
+// Passes a game's events on to the UI, until the game is abandoned
+func NewForwarder(ctx context.Context, gameUI chan<- c4.Event) func(c4.Event) {
+	return func(e c4.Event) {
+		select {
+		case gameUI <- e:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Formats a clock as minutes and seconds, rounding up so that a clock only
+// shows 0:00 once it has run out
+func formatClock(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
 var redImage, blackImage, noneImage *sdl.Surface
 
-func drawPiece(s *sdl.Surface, col, row int, p c4.Piece) {
+func drawPiece(s *sdl.Surface, rules c4.Rules, col, row int, p c4.Piece) {
 	// Load images
 	if redImage == nil {
 		redImage = sdl.Load("red.png")
@@ -58,8 +88,8 @@ func drawPiece(s *sdl.Surface, col, row int, p c4.Piece) {
 	// Draw image
 	s.Blit(
 		&sdl.Rect{
-			int16(SCREEN_WIDTH * col / c4.MaxColumns),
-			int16(SCREEN_HEIGHT * (c4.MaxRows - row - 1) / c4.MaxRows),
+			int16(SCREEN_WIDTH * col / rules.Columns),
+			int16(SCREEN_HEIGHT * (rules.Rows - row - 1) / rules.Rows),
 			0,
 			0},
 		image,
@@ -70,6 +100,28 @@ func main() {
 	// Use all processors
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	var rules c4.Rules
+	flag.IntVar(&rules.Columns, "columns", c4.StandardRules.Columns,
+		"the number of columns on the board")
+	flag.IntVar(&rules.Rows, "rows", c4.StandardRules.Rows,
+		"the number of rows on the board")
+	flag.IntVar(&rules.WinCount, "win", c4.StandardRules.WinCount,
+		"the length of the line needed to win")
+	var clock c4.TimeControl
+	flag.DurationVar(&clock.Base, "time", 0,
+		"the time on each player's clock, or 0 for no clock")
+	flag.DurationVar(&clock.Increment, "increment", 0,
+		"the time added to a player's clock after each move")
+	flag.DurationVar(&clock.PerMove, "movetime", 0,
+		"the time each player gets for every move, instead of a clock")
+	flag.Parse()
+	if err := rules.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	SCREEN_WIDTH = PIECE_WIDTH * rules.Columns
+	SCREEN_HEIGHT = PIECE_HEIGHT * rules.Rows
+
 	// SDL voodoo
 	if sdl.Init(sdl.INIT_VIDEO) != 0 {
 		panic(sdl.GetError())
@@ -81,39 +133,61 @@ func main() {
 	}
 	defer ttf.Quit()
 
-	screen := sdl.SetVideoMode(640, 480, 32, sdl.ANYFORMAT)
+	screen := sdl.SetVideoMode(SCREEN_WIDTH, SCREEN_HEIGHT, 32, sdl.ANYFORMAT)
 	if screen == nil {
 		panic(sdl.GetError())
 	}
 	screen.SetAlpha(sdl.SRCALPHA, 255)
 
 	sdl.WM_SetCaption("Connect Four", "")
+	// The clocks are shown in the caption, with the one that's running
+	// counting down from when the turn started
+	timed := clock != c4.TimeControl{}
+	var clocks [2]time.Duration
+	var clockRunning c4.Piece
+	var turnStarted time.Time
+	var caption string
 
 	ticker := time.NewTicker(time.Second / 60 /*60 Hz*/)
 
-	// Make some pipes for communicating with the game logic
-	moveReady := make(chan int)
-	newState := make(chan c4.State)
-	nextMove := make(chan int)
-	gameResults := make(chan c4.Piece)
-	var winner c4.Piece
+	// Pipes for communicating with the game logic. Every game gets its own,
+	// so an abandoned game can't get mixed up with the next one. Moves are
+	// buffered, so a click can't block if the clock has just run out and
+	// the match has stopped waiting for it.
+	var events chan c4.Event
+	var nextMove chan int
 	var game c4.State
-	waitingForMove := false
-	gameOver := false
+	var cancelGame context.CancelFunc
+	var waitingForMove, gameOver bool
 
 	// Get ready to write text
 	font := ttf.OpenFont("DroidSans.ttf", 36)
 	var line1, line2 *sdl.Surface
 	showMessage := false
 
-	// Start a game
+	// Start a game, abandoning the last one if it's still going
 	startGame := func() {
-		c4.RunGame(
-			SDLHuman{moveReady, nextMove},
-			c4.AlphaBetaAI{
-				c4.Black,
-				8,
-				func(game c4.State, p c4.Piece) float64 {
+		if cancelGame != nil {
+			cancelGame()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelGame = cancel
+		events = make(chan c4.Event)
+		nextMove = make(chan int, 1)
+		game = c4.NewState(rules)
+		waitingForMove = false
+		gameOver = false
+		showMessage = false
+
+		match := &c4.Match{
+			Rules:  rules,
+			Policy: c4.RetryForever,
+			Clock:  clock,
+			Red:    SDLHuman{nextMove},
+			Black: c4.AlphaBetaAI{
+				Color: c4.Black,
+				Depth: 8,
+				EvalFunc: func(game c4.State, p c4.Piece) float64 {
 					// Evolved solution:
 					// return c4.EvalFactors{
 					// 		0.2502943943301069,
@@ -126,50 +200,41 @@ func main() {
 					return c4.EvalFactors{
 						0.32386133725050104, 0.5490470895311659, 0.3932539698522742, -0.27424526114286796, 0.4746881136468789, 0.2091091126568151}.Eval(game, p)
 				},
-				func(game c4.State) bool {
+				TerminalTest: func(game c4.State) bool {
 					return game.GetWinner() != c4.None
 				},
+				Table: c4.NewTranspositionTable(64),
 			},
-			NewUpdater(newState),
-			func(err error) {
-				fmt.Println(err)
-			},
-			func(winner c4.Piece) {
-				if winner == c4.Red {
-					gameResults <- c4.Red
-				} else if winner == c4.Black {
-					gameResults <- c4.Black
-				} else {
-					gameResults <- c4.None
-				}
-			})
+		}
+		match.Subscribe(NewForwarder(ctx, events))
+		go match.Play(ctx)
 	}
-	go startGame()
+	startGame()
 
 loop:
 	for {
 		select {
 		case <-ticker.C:
 			screen.FillRect(
-				&sdl.Rect{0, 0, SCREEN_WIDTH, SCREEN_HEIGHT},
+				&sdl.Rect{0, 0, uint16(SCREEN_WIDTH), uint16(SCREEN_HEIGHT)},
 				BOARD_COLOR)
-			for col := 0; col < c4.MaxColumns; col++ {
-				for row := 0; row < c4.MaxRows; row++ {
-					drawPiece(screen, col, row, game.GetPiece(col, row))
+			for col := 0; col < rules.Columns; col++ {
+				for row := 0; row < rules.Rows; row++ {
+					drawPiece(screen, rules, col, row, game.GetPiece(col, row))
 				}
 			}
 			if showMessage {
 				screen.Blit(
 					&sdl.Rect{
-						int16(SCREEN_WIDTH/2 - line1.W/2),
-						int16(SCREEN_HEIGHT/2 - line1.H),
+						int16(SCREEN_WIDTH/2 - int(line1.W)/2),
+						int16(SCREEN_HEIGHT/2 - int(line1.H)),
 						0,
 						0},
 					line1,
 					nil)
 				screen.Blit(
 					&sdl.Rect{
-						int16(SCREEN_WIDTH/2 - line2.W/2),
+						int16(SCREEN_WIDTH/2 - int(line2.W)/2),
 						int16(SCREEN_HEIGHT / 2),
 						0,
 						0},
@@ -178,6 +243,19 @@ loop:
 			}
 			screen.Flip()
 
+			if timed {
+				left := clocks
+				if clockRunning != c4.None {
+					left[clockRunning-1] -= time.Since(turnStarted)
+				}
+				newCaption := fmt.Sprintf("Connect Four - Red %v, Black %v",
+					formatClock(left[0]), formatClock(left[1]))
+				if newCaption != caption {
+					caption = newCaption
+					sdl.WM_SetCaption(caption, "")
+				}
+			}
+
 		case event := <-sdl.Events:
 			switch e := event.(type) {
 			case sdl.MouseButtonEvent:
@@ -185,7 +263,7 @@ loop:
 					e.Type == sdl.MOUSEBUTTONUP &&
 					e.Button == sdl.BUTTON_LEFT {
 					waitingForMove = false
-					nextMove <- int(e.X * c4.MaxColumns / SCREEN_WIDTH)
+					nextMove <- int(e.X) * rules.Columns / SCREEN_WIDTH
 
 					// Tell user that the AI is thinking now
 					line1 =
@@ -195,45 +273,77 @@ loop:
 							"Thinking...",
 							sdl.Color{255, 255, 255, 0})
 					showMessage = true
+				} else if waitingForMove &&
+					e.Type == sdl.MOUSEBUTTONUP &&
+					e.Button == sdl.BUTTON_RIGHT {
+					waitingForMove = false
+					nextMove <- c4.TakeBack
 				} else if gameOver &&
 					e.Type == sdl.MOUSEBUTTONUP &&
 					e.Button == sdl.BUTTON_LEFT {
-					gameOver = false
-					showMessage = false
-					go startGame()
+					startGame()
+				}
+			case sdl.KeyboardEvent:
+				// U also takes back a move
+				if waitingForMove &&
+					e.Type == sdl.KEYDOWN &&
+					e.Keysym.Sym == sdl.K_u {
+					waitingForMove = false
+					nextMove <- c4.TakeBack
+				} else if e.Type == sdl.KEYDOWN &&
+					e.Keysym.Sym == sdl.K_n {
+					// N starts over, even while the AI is thinking
+					startGame()
 				}
 			case sdl.QuitEvent:
+				cancelGame()
 				break loop
 			}
 
-		case game = <-newState:
-			// We did the assignment; there's nothing else to do
-
-		case <-moveReady:
-			waitingForMove = true
-			showMessage = false
-
-		case winner = <-gameResults:
-			gameOver = true
-			var message string
-			if winner == c4.Red {
-				message = "You win!"
-			} else if winner == c4.Black {
-				message = "You lose."
-			} else {
-				message = "Draw."
-			}
-			line1 =
-				ttf.RenderText_Blended(font,
-					message,
-					sdl.Color{255, 255, 255, 0})
+		case e := <-events:
+			game = e.Game
+			clocks = [2]time.Duration{e.RedClock, e.BlackClock}
+			clockRunning = c4.None
+			switch e.Kind {
+			case c4.TurnStarted:
+				clockRunning = e.Player
+				turnStarted = time.Now()
+				if e.Player == c4.Red {
+					// Drop a move that came too late for the last turn
+					select {
+					case <-nextMove:
+					default:
+					}
+					waitingForMove = true
+					showMessage = false
+				}
+			case c4.IllegalMove:
+				fmt.Println(e.Err)
+			case c4.GameOver:
+				gameOver = true
+				waitingForMove = false
+				var message string
+				if e.Result.Winner == c4.Red {
+					message = "You win!"
+				} else if e.Result.OutOfTime {
+					message = "Out of time."
+				} else if e.Result.Winner == c4.Black {
+					message = "You lose."
+				} else {
+					message = "Draw."
+				}
+				line1 =
+					ttf.RenderText_Blended(font,
+						message,
+						sdl.Color{255, 255, 255, 0})
 
-			line2 =
-				ttf.RenderText_Blended(font,
-					"Click to play again...",
-					sdl.Color{255, 255, 255, 0})
+				line2 =
+					ttf.RenderText_Blended(font,
+						"Click to play again...",
+						sdl.Color{255, 255, 255, 0})
 
-			showMessage = true
+				showMessage = true
+			}
 		}
 
 	}
@@ -2,15 +2,26 @@ package main
 
 import (
 	"../c4"
+	"../c4/book"
+	"../c4/external"
+	"bufio"
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func textShow(game c4.State) {
 	// Board
 	var piece c4.Piece
-	for row := c4.MaxRows - 1; row >= 0; row-- {
-		for col := 0; col < c4.MaxColumns; col++ {
+	rules := game.GetRules()
+	for row := rules.Rows - 1; row >= 0; row-- {
+		for col := 0; col < rules.Columns; col++ {
 			piece = game.GetPiece(col, row)
 			if piece == c4.Red {
 				fmt.Print("R")
@@ -22,8 +33,9 @@ func textShow(game c4.State) {
 		}
 		fmt.Println()
 	}
-	for col := 0; col < c4.MaxColumns; col++ {
-		fmt.Print(col)
+	// Columns past 9 are numbered with letters
+	for col := 0; col < rules.Columns; col++ {
+		fmt.Print(strconv.FormatInt(int64(col), 36))
 	}
 	fmt.Print("\n\n")
 	// Turn
@@ -35,33 +47,191 @@ func textShow(game c4.State) {
 	}
 }
 
-type TextHuman struct{}
+// The name of a player for reports
+func pieceName(p c4.Piece) string {
+	if p == c4.Red {
+		return "Red"
+	}
+	return "Black"
+}
+
+// Shows who controls the zugzwang and the rules from Allis' thesis that stop
+// the other player's lines
+func zugzwangShow(game c4.State) {
+	a := c4.AnalyzeZugzwang(game)
+	controller := pieceName(a.Controller)
+	other := strings.ToLower(pieceName(a.Controller.Other()))
+	fmt.Printf("%v controls the zugzwang.\n", controller)
+	if a.Move >= 0 {
+		fmt.Printf("After %v plays in column %v:\n", controller,
+			strconv.FormatInt(int64(a.Move), 36))
+	}
+	for _, s := range a.Solutions {
+		l := s.Locations
+		fmt.Printf("  %v: column %v row %v, column %v row %v, stopping %v "+
+			"of %v's lines\n", c4.RuleNames[s.Rule],
+			strconv.FormatInt(int64(l[0].Col), 36), l[0].Row+1,
+			strconv.FormatInt(int64(l[1].Col), 36), l[1].Row+1, s.Lines, other)
+	}
+	switch {
+	case a.Win:
+		fmt.Printf("%v wins by Allis' rules.\n", controller)
+	case a.Refuted:
+		fmt.Printf("%v can't lose by Allis' rules.\n", controller)
+	default:
+		fmt.Printf("Allis' rules don't stop all of %v's %v lines.\n", other,
+			a.Lines)
+	}
+}
+
+// Shows the time left on both clocks
+func clockShow(e c4.Event) {
+	fmt.Printf("Red has %v left, black has %v left.\n",
+		formatClock(e.RedClock), formatClock(e.BlackClock))
+}
+
+// Formats a clock as minutes, seconds and tenths of a second
+func formatClock(d time.Duration) string {
+	d = d.Truncate(time.Second / 10)
+	return fmt.Sprintf("%d:%04.1f",
+		int(d/time.Minute), (d % time.Minute).Seconds())
+}
+
+// Reads lines as they're typed, so a move can be given up on when the clock
+// runs out
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return lines
+}
+
+// A person typing moves. When there's nothing more to read, Quit is called
+// to abandon the game.
+type TextHuman struct {
+	Lines <-chan string
+	Quit  context.CancelFunc
+}
 
 func (ui TextHuman) NextMove(game c4.State) int {
-	var col int
+	return ui.NextMoveContext(context.Background(), game)
+}
+
+// Waits for a column to be typed. If the game is abandoned, or there's
+// nothing more to read, this returns MaxColumns, which is never a column.
+func (ui TextHuman) NextMoveContext(ctx context.Context, game c4.State) int {
 	for {
-		fmt.Print("Enter the column to place your piece: ")
+		fmt.Print("Enter the column to place your piece, or u to undo: ")
 
-		_, err := fmt.Scanln(&col)
-		if err == nil {
-			return col
-		} else {
+		select {
+		case input, ok := <-ui.Lines:
+			if !ok {
+				// There's nothing more to read, so nobody is playing
+				fmt.Println()
+				ui.Quit()
+				return c4.MaxColumns
+			}
+			input = strings.TrimSpace(input)
+			if input == "u" {
+				return c4.TakeBack
+			}
+			if col, err := strconv.ParseInt(input, 36, 0); err == nil {
+				return int(col)
+			}
+		case <-ctx.Done():
 			fmt.Println()
+			return c4.MaxColumns
 		}
 	}
-	return 0
+}
+
+// Starts an engine from a command line like "engine" or "./engine -x 2",
+// exiting if it doesn't start
+func startEngine(command string) *external.Engine {
+	fields := strings.Fields(command)
+	e, err := external.Start(fields[0], fields[1:]...)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return e
+}
+
+// Reads an opening book, exiting if it can't
+func readBook(name string) *book.Book {
+	file, err := os.Open(name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	b, err := book.Read(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return b
 }
 
 func main() {
 	// Use all processors
 	runtime.GOMAXPROCS(runtime.NumCPU())
-	c4.RunGame(
-		//TextHuman{},
 
-		c4.AlphaBetaAI{
-			c4.Red,
-			8,
-			func(game c4.State, p c4.Piece) float64 {
+	var rules c4.Rules
+	flag.IntVar(&rules.Columns, "columns", c4.StandardRules.Columns,
+		"the number of columns on the board")
+	flag.IntVar(&rules.Rows, "rows", c4.StandardRules.Rows,
+		"the number of rows on the board")
+	flag.IntVar(&rules.WinCount, "win", c4.StandardRules.WinCount,
+		"the length of the line needed to win")
+	var clock c4.TimeControl
+	flag.DurationVar(&clock.Base, "time", 0,
+		"the time on each player's clock, or 0 for no clock")
+	flag.DurationVar(&clock.Increment, "increment", 0,
+		"the time added to a player's clock after each move")
+	flag.DurationVar(&clock.PerMove, "movetime", 0,
+		"the time each player gets for every move, instead of a clock")
+	human := flag.String("human", "red",
+		"the side you play: red, black or none")
+	redEngine := flag.String("red-engine", "",
+		"the command line of an engine to play red")
+	blackEngine := flag.String("black-engine", "",
+		"the command line of an engine to play black")
+	bookFile := flag.String("book", "",
+		"an opening book for the computer to play from")
+	zugzwang := flag.Bool("zugzwang", false,
+		"show who controls the zugzwang after every move, and whether "+
+			"Allis' rules decide the game")
+	flag.Parse()
+	if err := rules.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	switch {
+	case *human != "red" && *human != "black" && *human != "none":
+		fmt.Printf("Unknown side %v\n", *human)
+		os.Exit(2)
+	case *human == "red" && *redEngine != "",
+		*human == "black" && *blackEngine != "":
+		fmt.Println("An engine can't play your side")
+		os.Exit(2)
+	}
+
+	ctx, quit := context.WithCancel(context.Background())
+	defer quit()
+	match := &c4.Match{
+		Rules:  rules,
+		Policy: c4.RetryForever,
+		Clock:  clock,
+		Red: c4.AlphaBetaAI{
+			Color: c4.Red,
+			Depth: 8,
+			EvalFunc: func(game c4.State, p c4.Piece) float64 {
 				return c4.EvalFactors{
 					0.2502943943301069,
 					-0.4952316649483701,
@@ -70,32 +240,85 @@ func main() {
 					0.4746881137884282,
 					0.2091091127191147}.Eval(game, p)
 			},
-			func(game c4.State) bool {
+			TerminalTest: func(game c4.State) bool {
 				return game.GetWinner() != c4.None
 			},
+			Table: c4.NewTranspositionTable(64),
 		},
-		c4.AlphaBetaAI{
-			c4.Black,
-			8,
-			func(game c4.State, p c4.Piece) float64 {
+		Black: c4.AlphaBetaAI{
+			Color: c4.Black,
+			Depth: 8,
+			EvalFunc: func(game c4.State, p c4.Piece) float64 {
 				return c4.EvalFactors{
 					-0.44025376981519854, -0.984130509442473, 3.1687077228958405, 3.1025578581098, 2.963961809218915, 3.321618870088799}.Eval(game, p)
 			},
-			func(game c4.State) bool {
+			TerminalTest: func(game c4.State) bool {
 				return game.GetWinner() != c4.None
 			},
+			Table: c4.NewTranspositionTable(64),
 		},
-		textShow,
-		func(err error) {
-			fmt.Println(err)
-		},
-		func(winner c4.Piece) {
-			if winner == c4.Red {
-				fmt.Println("Red wins!")
-			} else if winner == c4.Black {
-				fmt.Println("Black wins!")
-			} else {
-				fmt.Println("It's a tie.")
+	}
+	// The AI plays from the book while it can
+	if *bookFile != "" {
+		b := readBook(*bookFile)
+		match.Red = book.BookPlayer{Book: b, Player: match.Red}
+		match.Black = book.BookPlayer{Book: b, Player: match.Black}
+	}
+	// You and the engines take the place of the AI
+	switch *human {
+	case "red":
+		match.Red = TextHuman{readLines(os.Stdin), quit}
+	case "black":
+		match.Black = TextHuman{readLines(os.Stdin), quit}
+	}
+	if *redEngine != "" {
+		e := startEngine(*redEngine)
+		defer e.Close()
+		match.Red = e
+	}
+	if *blackEngine != "" {
+		e := startEngine(*blackEngine)
+		defer e.Close()
+		match.Black = e
+	}
+	timed := clock != c4.TimeControl{}
+	match.Subscribe(func(e c4.Event) {
+		switch e.Kind {
+		case c4.TurnStarted:
+			textShow(e.Game)
+			if timed {
+				clockShow(e)
 			}
-		})
+			if *zugzwang {
+				zugzwangShow(e.Game)
+			}
+		case c4.IllegalMove:
+			fmt.Println(e.Err)
+		case c4.GameOver:
+			textShow(e.Game)
+			if timed {
+				clockShow(e)
+			}
+		}
+	})
+
+	result, err := match.Play(ctx)
+	if err != nil {
+		fmt.Println("The game was abandoned.")
+		return
+	}
+	if result.OutOfTime {
+		if result.Winner == c4.Red {
+			fmt.Println("Black ran out of time.")
+		} else {
+			fmt.Println("Red ran out of time.")
+		}
+	}
+	if result.Winner == c4.Red {
+		fmt.Println("Red wins!")
+	} else if result.Winner == c4.Black {
+		fmt.Println("Black wins!")
+	} else {
+		fmt.Println("It's a tie.")
+	}
 }
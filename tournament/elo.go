@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// The results of the games between two players, from one player's point of
+// view
+type score struct {
+	Wins, Draws, Losses int
+}
+
+func (s score) games() int {
+	return s.Wins + s.Draws + s.Losses
+}
+
+func (s score) add(o score) score {
+	return score{s.Wins + o.Wins, s.Draws + o.Draws, s.Losses + o.Losses}
+}
+
+// The same results from the other player's point of view
+func (s score) reversed() score {
+	return score{s.Losses, s.Draws, s.Wins}
+}
+
+// The average points per game, counting a win as 1 and a draw as 1/2, and
+// the variance of the points in a single game
+func (s score) meanAndVariance() (mean, variance float64) {
+	n := float64(s.games())
+	if n == 0 {
+		return 0.5, 0
+	}
+	w, d, l := float64(s.Wins)/n, float64(s.Draws)/n, float64(s.Losses)/n
+	mean = w + d/2
+	variance = w*(1-mean)*(1-mean) + d*(0.5-mean)*(0.5-mean) +
+		l*mean*mean
+	return
+}
+
+// The difference in Elo ratings that makes a player expect to score this
+// fraction of the points
+func eloDiff(fraction float64) float64 {
+	return -400 * math.Log10(1/fraction-1)
+}
+
+// The fraction of the points a player expects to score against someone this
+// many Elo points weaker
+func expectedScore(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// The Elo difference these results show, and how far off it could be with
+// 95% confidence
+func (s score) elo() (elo, margin float64) {
+	mean, variance := s.meanAndVariance()
+	elo = eloDiff(mean)
+	deviation := math.Sqrt(variance / float64(s.games()))
+	if s.games() == 0 || deviation == 0 {
+		return elo, math.Inf(1)
+	}
+	// Scores of 0 or 1 are infinitely far away
+	low := math.Max(mean-1.96*deviation, 1e-9)
+	high := math.Min(mean+1.96*deviation, 1-1e-9)
+	return elo, (eloDiff(high) - eloDiff(low)) / 2
+}
+
+func formatElo(elo, margin float64) string {
+	if math.IsInf(elo, 0) || math.IsNaN(elo) {
+		return fmt.Sprintf("%+v", elo)
+	}
+	if math.IsInf(margin, 0) {
+		return fmt.Sprintf("%+.0f", elo)
+	}
+	return fmt.Sprintf("%+.0f ± %.0f", elo, margin)
+}
+
+// A sequential probability ratio test of whether a player is elo1 Elo points
+// stronger than another, rather than elo0. False positives happen with
+// probability alpha, and false negatives with probability beta.
+type sprt struct {
+	Elo0, Elo1  float64
+	Alpha, Beta float64
+}
+
+// The bounds of the log-likelihood ratio. Going below the lower one accepts
+// elo0, and going above the upper one accepts elo1.
+func (t sprt) bounds() (lower, upper float64) {
+	return math.Log(t.Beta / (1 - t.Alpha)), math.Log((1 - t.Beta) / t.Alpha)
+}
+
+// The log-likelihood ratio of the results so far, using the normal
+// approximation to the distribution of the average score. While every game
+// has had the same result, the variance is worked out as if there had also
+// been a win and a loss, so that a clean sweep can still decide the test.
+func (t sprt) llr(s score) float64 {
+	if s.games() == 0 {
+		return 0
+	}
+	mean, variance := s.meanAndVariance()
+	if variance == 0 {
+		_, variance = s.add(score{Wins: 1, Losses: 1}).meanAndVariance()
+	}
+	s0, s1 := expectedScore(t.Elo0), expectedScore(t.Elo1)
+	n := float64(s.games())
+	return n * (s1 - s0) * (2*mean - s0 - s1) / (2 * variance)
+}
+
+// Decides between elo0 and elo1, returning -1 to accept elo0, 1 to accept
+// elo1, and 0 if more games are needed
+func (t sprt) decide(s score) int {
+	llr := t.llr(s)
+	lower, upper := t.bounds()
+	if llr <= lower {
+		return -1
+	}
+	if llr >= upper {
+		return 1
+	}
+	return 0
+}
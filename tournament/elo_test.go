@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEloAndExpectedScore(t *testing.T) {
+	cases := []struct {
+		elo, score float64
+	}{
+		{0, 0.5},
+		{400, 10.0 / 11},
+		{-400, 1.0 / 11},
+		{800, 100.0 / 101},
+		{35, 1 / (1 + math.Pow(10, -35.0/400))},
+	}
+	for _, c := range cases {
+		if score := expectedScore(c.elo); math.Abs(score-c.score) > 1e-12 {
+			t.Errorf("Expected score at %v Elo is %v, want %v", c.elo,
+				score, c.score)
+		}
+		if elo := eloDiff(c.score); math.Abs(elo-c.elo) > 1e-9 {
+			t.Errorf("Elo for scoring %v is %v, want %v", c.score, elo,
+				c.elo)
+		}
+	}
+	if !math.IsInf(eloDiff(1), 1) || !math.IsInf(eloDiff(0), -1) {
+		t.Errorf("Elo for scoring 1 and 0 is %v and %v", eloDiff(1),
+			eloDiff(0))
+	}
+}
+
+func TestScoreElo(t *testing.T) {
+	cases := []struct {
+		s            score
+		elo          float64
+		finiteMargin bool
+	}{
+		{score{}, 0, false},
+		{score{Wins: 10, Losses: 10}, 0, true},
+		{score{Draws: 7}, 0, false},
+		{score{Wins: 6, Draws: 2, Losses: 2}, eloDiff(0.7), true},
+		{score{Wins: 2, Draws: 2, Losses: 6}, eloDiff(0.3), true},
+		{score{Wins: 5}, math.Inf(1), false},
+	}
+	for _, c := range cases {
+		elo, margin := c.s.elo()
+		if elo != c.elo && math.Abs(elo-c.elo) > 1e-9 {
+			t.Errorf("%+v has Elo %v, want %v", c.s, elo, c.elo)
+		}
+		if math.IsInf(margin, 0) == c.finiteMargin ||
+			c.finiteMargin && margin <= 0 {
+			t.Errorf("%+v has margin %v", c.s, margin)
+		}
+	}
+
+	// Ten times the games gives about a third of the margin
+	_, small := score{Wins: 60, Draws: 20, Losses: 20}.elo()
+	_, large := score{Wins: 600, Draws: 200, Losses: 200}.elo()
+	if ratio := small / large; ratio < 3 || ratio > 3.4 {
+		t.Errorf("Margins %v and %v have ratio %v", small, large, ratio)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	test := sprt{Elo0: 0, Elo1: 10, Alpha: 0.05, Beta: 0.05}
+	lower, upper := test.bounds()
+	if math.Abs(lower+math.Log(19)) > 1e-12 ||
+		math.Abs(upper-math.Log(19)) > 1e-12 {
+		t.Errorf("Bounds are %v and %v, want ±%v", lower, upper,
+			math.Log(19))
+	}
+
+	if llr := test.llr(score{}); llr != 0 {
+		t.Errorf("No games have LLR %v", llr)
+	}
+
+	// A clean sweep uses the variance it would have with a win and a loss
+	// more
+	s0, s1 := expectedScore(test.Elo0), expectedScore(test.Elo1)
+	_, variance := score{Wins: 6, Losses: 1}.meanAndVariance()
+	want := 5 * (s1 - s0) * (2 - s0 - s1) / (2 * variance)
+	if llr := test.llr(score{Wins: 5}); math.Abs(llr-want) > 1e-12 {
+		t.Errorf("Five wins have LLR %v, want %v", llr, want)
+	}
+	_, variance = score{Draws: 4, Wins: 1, Losses: 1}.meanAndVariance()
+	want = 4 * (s1 - s0) * (1 - s0 - s1) / (2 * variance)
+	if llr := test.llr(score{Draws: 4}); math.Abs(llr-want) > 1e-12 {
+		t.Errorf("Four draws have LLR %v, want %v", llr, want)
+	}
+
+	cases := []struct {
+		s        score
+		decision int
+	}{
+		{score{}, 0},
+		{score{Wins: 3, Draws: 2, Losses: 2}, 0},
+		{score{Wins: 100}, 1},
+		{score{Losses: 100}, -1},
+		{score{Wins: 5600, Losses: 4400}, 1},
+		{score{Wins: 5000, Losses: 5000}, -1},
+	}
+	for _, c := range cases {
+		if decision := test.decide(c.s); decision != c.decision {
+			t.Errorf("%+v with LLR %v decided %v, want %v", c.s,
+				test.llr(c.s), decision, c.decision)
+		}
+	}
+}
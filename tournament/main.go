@@ -0,0 +1,510 @@
+package main
+
+// Plays players against each other to find out which is strongest. Players
+// are read from a JSON file holding a list like
+//
+//	[
+//		{"Name": "evolved", "Depth": 8,
+//		 "Coefficients": [0.25, -0.50, 0.39, -0.27, 0.47, 0.21]},
+//		{"Name": "deeper", "Depth": 10, "MoveTime": "200ms", "Hash": 16},
+//		{"Name": "centered", "Weights": {"win": 0.25, "center": 0.05}},
+//		{"Name": "network", "Network": "network.json", "Depth": 6},
+//		{"Name": "engine", "Engine": "./engine/engine", "Go": "depth 8"}
+//	]
+//
+// AIs search to Depth, or for MoveTime on each move, or both. Their
+// evaluator adds up the features named in Weights, or uses the six
+// Coefficients of c4.EvalFactors, or the ones found by ga if there are
+// neither, or the neural network in the file named by Network, as written
+// by train-mlp. They get a transposition table of Hash megabytes if it's set. Players
+// with an Engine are run with that command line instead, and are sent Go to
+// start each search, as in the external package.
+//
+// Every pair of players plays the same number of games with each color,
+// starting from the same random openings. In a gauntlet, the first player
+// plays everyone else, who don't play each other. In an SPRT, the first
+// player is tested against the second until it's clear which of two Elo
+// differences is right, or the rounds run out.
+
+import (
+	"../c4"
+	"../c4/external"
+	"../c4/mlp"
+	"../c4/record"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How a player is set up in the players file
+type playerConfig struct {
+	Name         string
+	Depth        int
+	MoveTime     string
+	Coefficients []float64
+	Weights      map[string]float64
+	Network      string
+	Hash         int
+	Engine       string
+	Go           string
+}
+
+// The coefficients found by ga
+var evolvedFactors = []float64{
+	0.2502943943301069,
+	-0.4952316649483701,
+	0.3932539700819625,
+	-0.2742452616759889,
+	0.4746881137884282,
+	0.2091091127191147}
+
+// A player that's ready to have games started
+type player struct {
+	playerConfig
+	moveTime  time.Duration
+	evaluator c4.Evaluator
+}
+
+func readPlayers(name string, rules c4.Rules) ([]player, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var configs []playerConfig
+	if err := json.NewDecoder(file).Decode(&configs); err != nil {
+		return nil, err
+	}
+
+	players := make([]player, len(configs))
+	for i, config := range configs {
+		p := player{playerConfig: config}
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("Player %v", i+1)
+		}
+		if p.MoveTime != "" {
+			if p.moveTime, err = time.ParseDuration(p.MoveTime); err != nil {
+				return nil, errors.New(fmt.Sprintf(
+					"%v: Invalid move time %v", p.Name, p.MoveTime))
+			}
+		}
+		switch {
+		case p.Network != "":
+			n, err := readNetwork(p.Network)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%v: %v", p.Name, err))
+			}
+			if n.Rules != rules {
+				return nil, errors.New(fmt.Sprintf(
+					"%v: The network is for other rules", p.Name))
+			}
+			p.evaluator = n
+		case p.Weights != nil:
+			if p.evaluator, err = c4.NewLinearEvaluator(p.Weights); err != nil {
+				return nil, errors.New(fmt.Sprintf("%v: %v", p.Name, err))
+			}
+		case p.Coefficients == nil:
+			p.Coefficients = evolvedFactors
+			fallthrough
+		default:
+			c := p.Coefficients
+			if len(c) != 6 {
+				return nil, errors.New(fmt.Sprintf(
+					"%v: There must be 6 coefficients", p.Name))
+			}
+			p.evaluator = c4.EvalFactors{c[0], c[1], c[2], c[3], c[4], c[5]}
+		}
+		if p.Engine == "" && p.Depth == 0 {
+			if p.moveTime == 0 {
+				p.Depth = 8
+			} else {
+				p.Depth = -1
+			}
+		}
+		players[i] = p
+	}
+	return players, nil
+}
+
+func readNetwork(name string) (*mlp.Network, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return mlp.Read(file)
+}
+
+// Starts a player for a single game, returning it along with a function to
+// call when the game is over
+func (p player) start(color c4.Piece) (c4.Player, func(), error) {
+	if p.Engine != "" {
+		fields := strings.Fields(p.Engine)
+		e, err := external.Start(fields[0], fields[1:]...)
+		if err != nil {
+			return nil, nil, err
+		}
+		e.Go = p.Go
+		return e, func() { e.Close() }, nil
+	}
+
+	ai := c4.AlphaBetaAI{
+		Color:    color,
+		Depth:    p.Depth,
+		EvalFunc: p.evaluator.Eval,
+		TerminalTest: func(game c4.State) bool {
+			return game.GetWinner() != c4.None
+		},
+		MoveTime: p.moveTime,
+		// The games are already played in parallel
+		Workers: 1,
+	}
+	if incremental, ok := p.evaluator.(c4.IncrementalEvaluator); ok {
+		ai.Evaluator = incremental
+	}
+	if p.Hash > 0 {
+		ai.Table = c4.NewTranspositionTable(p.Hash)
+	}
+	return ai, func() {}, nil
+}
+
+func (p player) record() record.Player {
+	if p.Engine != "" {
+		return record.Player{Name: p.Name}
+	}
+	if p.Weights != nil {
+		return record.Player{Name: p.Name, Depth: p.Depth,
+			Weights: p.Weights}
+	}
+	if p.Network != "" {
+		return record.Player{Name: p.Name, Depth: p.Depth}
+	}
+	return record.Player{Name: p.Name, Depth: p.Depth,
+		Coefficients: p.Coefficients}
+}
+
+// A game to be played
+type game struct {
+	Red, Black int // The players' indexes
+	Opening    []int
+}
+
+// How a game went
+type gameResult struct {
+	game
+	Result c4.Result
+	Err    error
+}
+
+// Picks random moves for an opening that doesn't end the game
+func randomOpening(rules c4.Rules, plies int) []int {
+	for {
+		state := c4.NewState(rules)
+		for len(state.Moves()) < plies && !state.IsDone() {
+			state.Move(state.GetTurn(), rand.Intn(rules.Columns))
+		}
+		if !state.IsDone() {
+			return state.Moves()
+		}
+	}
+}
+
+// Everything about the tournament that's the same for every game
+type tournament struct {
+	Rules   c4.Rules
+	Clock   c4.TimeControl
+	Players []player
+	Event   string
+
+	games *record.Writer
+	mu    sync.Mutex
+}
+
+// Plays a game, writing its record if there's a game file
+func (t *tournament) play(ctx context.Context, g game) gameResult {
+	red, redDone, err := t.Players[g.Red].start(c4.Red)
+	if err != nil {
+		return gameResult{game: g, Err: err}
+	}
+	defer redDone()
+	black, blackDone, err := t.Players[g.Black].start(c4.Black)
+	if err != nil {
+		return gameResult{game: g, Err: err}
+	}
+	defer blackDone()
+
+	match := &c4.Match{
+		Rules: t.Rules,
+		Red:   red,
+		Black: black,
+		// A player that can't make a legal move, like an engine that
+		// crashed, loses
+		Policy:  c4.IllegalMovePolicy{Retries: 0, Forfeit: true},
+		Clock:   t.Clock,
+		Opening: g.Opening,
+	}
+	rec := record.New(t.Rules, t.Players[g.Red].record(),
+		t.Players[g.Black].record())
+	rec.Event = t.Event
+	if t.games != nil {
+		match.Record(rec)
+	}
+	result, err := match.Play(ctx)
+	if err != nil {
+		return gameResult{game: g, Result: result, Err: err}
+	}
+	if t.games != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if err := t.games.Write(rec); err != nil {
+			log.Println(err)
+		}
+	}
+	return gameResult{game: g, Result: result}
+}
+
+// Plays the games from the channel on a number of goroutines, until the
+// channel is closed or the context is done
+func (t *tournament) run(ctx context.Context, games <-chan game,
+	concurrency int) <-chan gameResult {
+	results := make(chan gameResult)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range games {
+				results <- t.play(ctx, g)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func formatResult(result c4.Result) string {
+	switch {
+	case result.Winner == c4.Red:
+		return "1-0"
+	case result.Winner == c4.Black:
+		return "0-1"
+	}
+	return "1/2-1/2"
+}
+
+func main() {
+	// Use all processors
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	// Initialize seed
+	rand.Seed(time.Now().UnixNano())
+
+	var rules c4.Rules
+	flag.IntVar(&rules.Columns, "columns", c4.StandardRules.Columns,
+		"the number of columns on the board")
+	flag.IntVar(&rules.Rows, "rows", c4.StandardRules.Rows,
+		"the number of rows on the board")
+	flag.IntVar(&rules.WinCount, "win", c4.StandardRules.WinCount,
+		"the length of the line needed to win")
+	var clock c4.TimeControl
+	flag.DurationVar(&clock.Base, "time", 0,
+		"the time on each player's clock, or 0 for no clock")
+	flag.DurationVar(&clock.Increment, "increment", 0,
+		"the time added to a player's clock after each move")
+	flag.DurationVar(&clock.PerMove, "movetime", 0,
+		"the time each player gets for every move, instead of a clock")
+	gauntlet := flag.Bool("gauntlet", false,
+		"only play the first player against the others")
+	rounds := flag.Int("rounds", 10,
+		"the number of openings each pair of players plays with both "+
+			"colors, or the most to play in an SPRT, or 0 for no limit")
+	plies := flag.Int("openings", 2,
+		"the number of random moves at the start of each game")
+	concurrency := flag.Int("concurrency", runtime.NumCPU(),
+		"the number of games to play at once")
+	gameFile := flag.String("games", "",
+		"a file to add records of the games to")
+	var test sprt
+	useSPRT := flag.Bool("sprt", false,
+		"test whether the first player is stronger than the second")
+	flag.Float64Var(&test.Elo0, "elo0", 0,
+		"the Elo difference the SPRT assumes if the change doesn't help")
+	flag.Float64Var(&test.Elo1, "elo1", 10,
+		"the Elo difference the SPRT assumes if the change helps")
+	flag.Float64Var(&test.Alpha, "alpha", 0.05,
+		"the chance the SPRT passes a change that doesn't help")
+	flag.Float64Var(&test.Beta, "beta", 0.05,
+		"the chance the SPRT fails a change that helps")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [options] <players file>\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	// A tournament without an SPRT has to stop somewhere
+	if flag.NArg() != 1 || *concurrency < 1 || *rounds == 0 && !*useSPRT {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := rules.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	players, err := readPlayers(flag.Arg(0), rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(players) < 2 || *useSPRT && len(players) != 2 {
+		log.Fatal("A tournament needs two players, or exactly two for an SPRT")
+	}
+
+	t := &tournament{
+		Rules:   rules,
+		Clock:   clock,
+		Players: players,
+		Event:   "tournament " + time.Now().Format("2006.01.02 15:04:05"),
+	}
+	if *gameFile != "" {
+		file, err := os.OpenFile(*gameFile,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		t.games = record.NewWriter(file)
+	}
+
+	// The pairs of players that play each other
+	var pairs [][2]int
+	for i := range players {
+		for j := i + 1; j < len(players); j++ {
+			if !*gauntlet || i == 0 {
+				pairs = append(pairs, [2]int{i, j})
+			}
+		}
+	}
+
+	// Every opening is played twice by each pair, swapping colors
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	games := make(chan game)
+	go func() {
+		defer close(games)
+		for round := 0; *rounds == 0 || round < *rounds; round++ {
+			for _, pair := range pairs {
+				opening := randomOpening(rules, *plies)
+				for _, g := range []game{
+					{pair[0], pair[1], opening},
+					{pair[1], pair[0], opening}} {
+					select {
+					case games <- g:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// scores[i][j] is how player i did against player j
+	scores := make([][]score, len(players))
+	for i := range scores {
+		scores[i] = make([]score, len(players))
+	}
+	played := 0
+	decided := false
+	for result := range t.run(ctx, games, *concurrency) {
+		if result.Err != nil {
+			if ctx.Err() == nil {
+				log.Printf("%v vs %v: %v", players[result.Red].Name,
+					players[result.Black].Name, result.Err)
+			}
+			continue
+		}
+		played++
+		var s score
+		switch result.Result.Winner {
+		case c4.Red:
+			s.Wins++
+		case c4.Black:
+			s.Losses++
+		default:
+			s.Draws++
+		}
+		scores[result.Red][result.Black] =
+			scores[result.Red][result.Black].add(s)
+		scores[result.Black][result.Red] =
+			scores[result.Black][result.Red].add(s.reversed())
+
+		why := ""
+		if result.Result.Forfeit {
+			why = " (forfeit)"
+		} else if result.Result.OutOfTime {
+			why = " (out of time)"
+		}
+		fmt.Printf("Game %v: %v vs %v %v%v\n", played,
+			players[result.Red].Name, players[result.Black].Name,
+			formatResult(result.Result), why)
+
+		if *useSPRT && ctx.Err() == nil {
+			s := scores[0][1]
+			lower, upper := test.bounds()
+			fmt.Printf("LLR %.2f (%.2f, %.2f), %v-%v-%v\n", test.llr(s),
+				lower, upper, s.Wins, s.Draws, s.Losses)
+			if decision := test.decide(s); decision != 0 {
+				// Abandon the games that are still going
+				cancel()
+				decided = true
+				fmt.Println()
+				if decision > 0 {
+					fmt.Printf("H1 accepted: %v is stronger than %v.\n",
+						players[0].Name, players[1].Name)
+				} else {
+					fmt.Printf("H0 accepted: %v is not stronger than %v.\n",
+						players[0].Name, players[1].Name)
+				}
+			}
+		}
+	}
+
+	// Running out of rounds doesn't decide anything
+	if *useSPRT && !decided {
+		s := scores[0][1]
+		lower, upper := test.bounds()
+		fmt.Println()
+		fmt.Printf("Inconclusive after %v games: LLR %.2f is still between "+
+			"%.2f and %.2f.\n", s.games(), test.llr(s), lower, upper)
+	}
+
+	// Results between each pair, then each player against everyone they
+	// played
+	fmt.Println()
+	for _, pair := range pairs {
+		s := scores[pair[0]][pair[1]]
+		fmt.Printf("%v vs %v: %v-%v-%v, Elo %v\n",
+			players[pair[0]].Name, players[pair[1]].Name,
+			s.Wins, s.Draws, s.Losses, formatElo(s.elo()))
+	}
+	fmt.Println()
+	for i, p := range players {
+		var total score
+		for _, s := range scores[i] {
+			total = total.add(s)
+		}
+		mean, _ := total.meanAndVariance()
+		fmt.Printf("%v: %v-%v-%v, %.1f%%, Elo %v\n", p.Name,
+			total.Wins, total.Draws, total.Losses, mean*100,
+			formatElo(total.elo()))
+	}
+}
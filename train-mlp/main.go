@@ -0,0 +1,290 @@
+package main
+
+// Trains a neural network evaluator on positions labelled with who goes on
+// to win. With -source selfplay, the positions come from games the evolved
+// coefficients play against themselves from random openings, and each one
+// is labelled with the result of its game. With -source solver, they're
+// random positions labelled by solving them, which is slow for positions
+// near the start and only works with the standard rules. The positions from
+// a tenth of the games are kept aside to check that the network does as well
+// on games it hasn't seen, and the network is written after every epoch.
+
+import (
+	"../c4"
+	"../c4/mlp"
+	"../c4/solver"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The coefficients found by ga
+var evolvedFactors = c4.EvalFactors{
+	0.2502943943301069,
+	-0.4952316649483701,
+	0.3932539700819625,
+	-0.2742452616759889,
+	0.4746881137884282,
+	0.2091091127191147}
+
+// The target for the player to move in a game p won, or in a draw if p is
+// None
+func target(game c4.State, p c4.Piece) float64 {
+	switch p {
+	case c4.None:
+		return 0
+	case game.GetTurn():
+		return 1
+	}
+	return -1
+}
+
+// Plays games between AIs searching to depth, starting with plies random
+// moves, until there are enough positions from after the openings. The
+// positions are returned game by game.
+func selfPlay(rules c4.Rules, count, depth, plies int) [][]mlp.Example {
+	var games [][]mlp.Example
+	total := 0
+	for total < count {
+		game := c4.NewState(rules)
+		for len(game.Moves()) < plies && !game.IsDone() {
+			game.Move(game.GetTurn(), rand.Intn(rules.Columns))
+		}
+		var positions []c4.State
+		for !game.IsDone() {
+			positions = append(positions, game)
+			ai := c4.AlphaBetaAI{
+				Color:     game.GetTurn(),
+				Depth:     depth,
+				EvalFunc:  evolvedFactors.Eval,
+				Evaluator: evolvedFactors,
+				TerminalTest: func(game c4.State) bool {
+					return game.GetWinner() != c4.None
+				},
+				Workers: 1,
+			}
+			game.Move(game.GetTurn(), ai.NextMove(game))
+		}
+		winner := game.GetWinner()
+		var examples []mlp.Example
+		for _, position := range positions {
+			if total == count {
+				break
+			}
+			examples = append(examples,
+				mlp.Example{Game: position, Target: target(position, winner)})
+			total++
+		}
+		games = append(games, examples)
+		fmt.Printf("%v positions, %q\n", total, game.MoveString())
+	}
+	return games
+}
+
+// Solves positions made by playing between minPlies and maxPlies random
+// moves. Each position comes from a different game, so each is returned as
+// a game of its own.
+func solved(count, minPlies, maxPlies int) [][]mlp.Example {
+	s := solver.New()
+	var examples [][]mlp.Example
+	for len(examples) < count {
+		game := c4.NewState(c4.StandardRules)
+		plies := minPlies + rand.Intn(maxPlies-minPlies+1)
+		for len(game.Moves()) < plies && !game.IsDone() {
+			game.Move(game.GetTurn(), rand.Intn(c4.StandardRules.Columns))
+		}
+		if game.IsDone() {
+			continue
+		}
+		result := s.Solve(game)
+		examples = append(examples, []mlp.Example{
+			{Game: game, Target: target(game, result.Winner)}})
+		if len(examples)%100 == 0 {
+			fmt.Printf("%v positions\n", len(examples))
+		}
+	}
+	return examples
+}
+
+// Reads the sizes of the hidden layers, like "64,32"
+func parseHidden(s string) ([]int, error) {
+	var hidden []int
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 1 {
+			return nil, errors.New(fmt.Sprintf(
+				"Invalid layer size %q", field))
+		}
+		hidden = append(hidden, size)
+	}
+	return hidden, nil
+}
+
+func readNetwork(name string) (*mlp.Network, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return mlp.Read(file)
+}
+
+func writeNetwork(name string, n *mlp.Network) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := n.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func main() {
+	// Use all processors
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	// Initialize seed
+	rand.Seed(time.Now().UnixNano())
+
+	var rules c4.Rules
+	flag.IntVar(&rules.Columns, "columns", c4.StandardRules.Columns,
+		"the number of columns on the board")
+	flag.IntVar(&rules.Rows, "rows", c4.StandardRules.Rows,
+		"the number of rows on the board")
+	flag.IntVar(&rules.WinCount, "win", c4.StandardRules.WinCount,
+		"the length of the line needed to win")
+	source := flag.String("source", "selfplay",
+		"where positions come from: selfplay or solver")
+	positions := flag.Int("positions", 10000,
+		"the number of positions to train on")
+	depth := flag.Int("depth", 4, "how deep the AIs search in self-play")
+	plies := flag.Int("openings", 4,
+		"the number of random moves at the start of each self-play game")
+	minPlies := flag.Int("min-plies", 16,
+		"the fewest random moves in positions for the solver")
+	maxPlies := flag.Int("max-plies", 36,
+		"the most random moves in positions for the solver")
+	hiddenFlag := flag.String("hidden", "64,32",
+		"the sizes of the hidden layers, separated by commas")
+	initFile := flag.String("init", "",
+		"a network to carry on training, instead of a new one")
+	optimizer := flag.String("optimizer", "adam",
+		"how to follow the gradient: sgd or adam")
+	rate := flag.Float64("rate", 0,
+		"the learning rate, or 0 for the optimizer's usual one")
+	epochs := flag.Int("epochs", 20,
+		"the number of times to go through the positions")
+	batch := flag.Int("batch", 32, "the number of positions in each step")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %v [options] <network file>\n",
+			os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *positions < 10 || *batch < 1 || *epochs < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := rules.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	var opt int
+	switch *optimizer {
+	case "sgd":
+		opt = mlp.SGD
+	case "adam":
+		opt = mlp.Adam
+	default:
+		fmt.Printf("Unknown optimizer %v\n", *optimizer)
+		os.Exit(2)
+	}
+	switch *source {
+	case "selfplay":
+	case "solver":
+		if rules != c4.StandardRules {
+			fmt.Println("The solver only works with the standard rules")
+			os.Exit(2)
+		}
+		if *minPlies < 0 || *maxPlies < *minPlies {
+			fmt.Println("The plies for the solver are out of order")
+			os.Exit(2)
+		}
+	default:
+		fmt.Printf("Unknown source %v\n", *source)
+		os.Exit(2)
+	}
+
+	var n *mlp.Network
+	if *initFile != "" {
+		var err error
+		if n, err = readNetwork(*initFile); err != nil {
+			log.Fatal(err)
+		}
+		if n.Rules != rules {
+			log.Fatal("The network is for other rules")
+		}
+	} else {
+		hidden, err := parseHidden(*hiddenFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		n = mlp.New(rules, hidden...)
+	}
+
+	var games [][]mlp.Example
+	if *source == "solver" {
+		games = solved(*positions, *minPlies, *maxPlies)
+	} else {
+		games = selfPlay(rules, *positions, *depth, *plies)
+	}
+	// Positions from the same game look alike, so whole games are held out
+	rand.Shuffle(len(games), func(i, j int) {
+		games[i], games[j] = games[j], games[i]
+	})
+	held := len(games) / 10
+	if held == 0 && len(games) > 1 {
+		held = 1
+	}
+	var test, train []mlp.Example
+	for i, game := range games {
+		if i < held {
+			test = append(test, game...)
+		} else {
+			train = append(train, game...)
+		}
+	}
+
+	t := mlp.NewTrainer(n, opt)
+	if *rate > 0 {
+		t.Rate = *rate
+	}
+	fmt.Printf("Before training: loss %.4f, held out %.4f\n",
+		n.Loss(train), n.Loss(test))
+	for epoch := 1; epoch <= *epochs; epoch++ {
+		rand.Shuffle(len(train), func(i, j int) {
+			train[i], train[j] = train[j], train[i]
+		})
+		for i := 0; i < len(train); i += *batch {
+			end := i + *batch
+			if end > len(train) {
+				end = len(train)
+			}
+			t.Train(train[i:end])
+		}
+		fmt.Printf("Epoch %v: loss %.4f, held out %.4f\n", epoch,
+			n.Loss(train), n.Loss(test))
+		if err := writeNetwork(flag.Arg(0), n); err != nil {
+			log.Fatal(err)
+		}
+	}
+}